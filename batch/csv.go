@@ -0,0 +1,196 @@
+// Package batch reads and writes the csv files of the batch valuation endpoint.
+package batch
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"vehicle-api/valuation"
+)
+
+// the columns a csv batch can have, vin and zip_code are required. options are separated by semicolons
+var Columns = []string{"vin", "zip_code", "mileage", "radius", "multiple_years", "model", "outliers", "include_certified", "trim", "condition", "options"}
+
+// a row of an uploaded csv. Error is set when a column couldn't be read, the row is then failed on its own
+// instead of failing the whole upload
+type Row struct {
+	VIN              string
+	ZipCode          string
+	Mileage          int
+	Radius           int
+	MultipleYears    bool
+	Model            string
+	Outliers         string
+	IncludeCertified bool
+	Trim             string
+	Condition        string
+	Options          []string
+	Error            string
+}
+
+// reads batch rows from a csv with a header row, unknown columns are ignored
+func ParseCSV(r io.Reader) ([]Row, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV is empty")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	//spreadsheets often save csv with a byte order mark
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	for _, required := range []string{"vin", "zip_code"} {
+		if _, ok := columns[required]; !ok {
+			return nil, errors.New("CSV must have a " + required + " column, available columns are " + strings.Join(Columns, ", "))
+		}
+	}
+
+	rows := []Row{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		value := func(column string) string {
+			i, ok := columns[column]
+			if !ok || i >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[i])
+		}
+
+		row := Row{
+			VIN:              value("vin"),
+			ZipCode:          value("zip_code"),
+			MultipleYears:    value("multiple_years") == "true",
+			Model:            value("model"),
+			Outliers:         value("outliers"),
+			IncludeCertified: value("include_certified") == "true",
+			Trim:             value("trim"),
+			Condition:        value("condition"),
+		}
+
+		//numbers can have thousands separators, the first one that can't be read is reported for the row
+		number := func(column string) int {
+			if value(column) == "" {
+				return 0
+			}
+			parsed, err := strconv.Atoi(strings.ReplaceAll(value(column), ",", ""))
+			if err != nil && row.Error == "" {
+				row.Error = column + " must be a whole number, got \"" + value(column) + "\""
+			}
+			return parsed
+		}
+		row.Mileage = number("mileage")
+		row.Radius = number("radius")
+
+		if options := value("options"); options != "" {
+			row.Options = strings.Split(options, ";")
+		}
+
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// the outcome of a row as it is written back. Row counts from 1, Valuation is nil when the row failed
+type Result struct {
+	Row       int
+	VIN       string
+	ZipCode   string
+	Mileage   int
+	Status    int
+	Error     string
+	Valuation *Valuation
+}
+
+// what a valued row adds to the csv
+type Valuation struct {
+	Year         int
+	Make         string
+	Model        string
+	Trim         string
+	Price        float64
+	Intervals    []valuation.Interval
+	ModelName    string
+	ListingsUsed int
+	Cached       bool
+	FetchedAt    time.Time
+}
+
+var header = []string{
+	"row", "vin", "zip_code", "mileage", "status", "error", "year", "make", "model", "trim", "predicted_price",
+	"low_80", "high_80", "low_95", "high_95", "valuation_model", "listings_used", "cached", "listings_fetched_at",
+}
+
+// writes the results as csv, one line per row in the order they were sent
+func WriteCSV(w io.Writer, results []Result) error {
+	writer := csv.NewWriter(w)
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+
+	//prices are rounded down to the cent like the json responses
+	money := func(value float64) string {
+		return strconv.FormatFloat(math.Floor(value*100)/100, 'f', 2, 64)
+	}
+
+	for _, result := range results {
+		record := make([]string, len(header))
+		record[0] = strconv.Itoa(result.Row)
+		record[1] = result.VIN
+		record[2] = result.ZipCode
+		record[4] = strconv.Itoa(result.Status)
+		record[5] = result.Error
+
+		if result.Mileage > 0 || result.Valuation != nil {
+			record[3] = strconv.Itoa(result.Mileage)
+		}
+
+		if v := result.Valuation; v != nil {
+			record[6] = strconv.Itoa(v.Year)
+			record[7] = v.Make
+			record[8] = v.Model
+			record[9] = v.Trim
+			record[10] = money(v.Price)
+
+			for _, interval := range v.Intervals {
+				switch interval.Confidence {
+				case 0.80:
+					record[11], record[12] = money(interval.Low), money(interval.High)
+				case 0.95:
+					record[13], record[14] = money(interval.Low), money(interval.High)
+				}
+			}
+
+			record[15] = v.ModelName
+			record[16] = strconv.Itoa(v.ListingsUsed)
+			record[17] = strconv.FormatBool(v.Cached)
+			record[18] = v.FetchedAt.UTC().Format(time.RFC3339)
+		}
+
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
@@ -0,0 +1,136 @@
+package batch
+
+import (
+	"bytes"
+	"encoding/csv"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+	"vehicle-api/valuation"
+)
+
+func TestParseCSV(t *testing.T) {
+	//saved by a spreadsheet, with a byte order mark, columns in their own order and an unknown column
+	data := "\ufeffZip_Code,VIN,Mileage,options,notes,multiple_years\n" +
+		"10001,1HGCM82633A004352,\"32,150\",sunroof;navigation,fleet car,true\n" +
+		"94103, 1M8GDM9AXKP042788 ,,,,\n"
+
+	rows, err := ParseCSV(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Row{
+		{VIN: "1HGCM82633A004352", ZipCode: "10001", Mileage: 32150, Options: []string{"sunroof", "navigation"}, MultipleYears: true},
+		{VIN: "1M8GDM9AXKP042788", ZipCode: "94103"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %+v, want %+v", rows, want)
+	}
+}
+
+func TestParseCSVNumbers(t *testing.T) {
+	data := "vin,zip_code,mileage,radius\n" +
+		"1HGCM82633A004352,10001,-1,50\n" +
+		"1HGCM82633A004352,10001,about 30k,50\n" +
+		"1HGCM82633A004352,10001,30000,far\n" +
+		"1HGCM82633A004352,10001,lots,far\n"
+
+	rows, err := ParseCSV(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 4 {
+		t.Fatalf("got %d rows, want 4", len(rows))
+	}
+
+	//a negative number is read as it is and left for validation to reject
+	if rows[0].Mileage != -1 || rows[0].Radius != 50 || rows[0].Error != "" {
+		t.Errorf("row 1 = %+v, want mileage -1 and no error", rows[0])
+	}
+
+	//numbers that can't be read name their column
+	for i, column := range []string{"mileage", "radius", "mileage"} {
+		row := rows[i+1]
+		if !strings.HasPrefix(row.Error, column+" must be a whole number") {
+			t.Errorf("row %d error is %q, want one about %s", i+2, row.Error, column)
+		}
+	}
+}
+
+func TestParseCSVErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"empty", "", "CSV is empty"},
+		{"no vin", "zip_code,mileage\n10001,30000\n", "CSV must have a vin column"},
+		{"no zip code", "\ufeffvin\n1HGCM82633A004352\n", "CSV must have a zip_code column"},
+		{"bad quoting", "vin,zip_code\n\"1HGCM82633A004352,10001\n", "extraneous or missing"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseCSV(strings.NewReader(test.data))
+			if err == nil || !strings.Contains(err.Error(), test.want) {
+				t.Errorf("got error %v, want one containing %q", err, test.want)
+			}
+		})
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	results := []Result{
+		{
+			Row: 1, VIN: "1HGCM82633A004352", ZipCode: "10001", Mileage: 32150, Status: 200,
+			Valuation: &Valuation{
+				Year: 2003, Make: "Honda", Model: "Accord", Trim: "EX", Price: 4321.999,
+				Intervals:    []valuation.Interval{{Confidence: 0.80, Low: 3900.5, High: 4700.255}, {Confidence: 0.95, Low: 3600, High: 5100}},
+				ModelName:    "log",
+				ListingsUsed: 42,
+				Cached:       true,
+				FetchedAt:    time.Date(2024, 3, 1, 12, 0, 0, 0, time.FixedZone("EST", -5*3600)),
+			},
+		},
+		{Row: 2, VIN: "1HGCM82633A004352", ZipCode: "10001", Mileage: -1, Status: 400, Error: "Radius, mileage and max_age can't be negative"},
+		{Row: 3, VIN: "1M8GDM9AXKP042788", ZipCode: "94103", Status: 422, Error: "Not enough results"},
+	}
+
+	var buffer bytes.Buffer
+	if err := WriteCSV(&buffer, results); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&buffer).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		header,
+		{"1", "1HGCM82633A004352", "10001", "32150", "200", "", "2003", "Honda", "Accord", "EX", "4321.99", "3900.50", "4700.25", "3600.00", "5100.00", "log", "42", "true", "2024-03-01T17:00:00Z"},
+		{"2", "1HGCM82633A004352", "10001", "", "400", "Radius, mileage and max_age can't be negative", "", "", "", "", "", "", "", "", "", "", "", "", ""},
+		{"3", "1M8GDM9AXKP042788", "94103", "", "422", "Not enough results", "", "", "", "", "", "", "", "", "", "", "", "", ""},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("csv = %v\nwant %v", records, want)
+	}
+}
+
+// a csv written for a batch can be sent back as one
+func TestWriteCSVRoundTrip(t *testing.T) {
+	var buffer bytes.Buffer
+	if err := WriteCSV(&buffer, []Result{{Row: 1, VIN: "1HGCM82633A004352", ZipCode: "10001", Mileage: 32150, Status: 200}}); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := ParseCSV(&buffer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].VIN != "1HGCM82633A004352" || rows[0].ZipCode != "10001" || rows[0].Mileage != 32150 || rows[0].Error != "" {
+		t.Errorf("rows = %+v", rows)
+	}
+}
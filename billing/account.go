@@ -0,0 +1,62 @@
+package billing
+
+import (
+	"context"
+	"log"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var keyCollection *mongo.Collection = configs.GetCollection(configs.DB, "keys")
+
+// reason set on accounts and keys deactivated because the customer's payment lapsed
+const paymentLapsed = models.DisabledPayment
+
+// activates the customer's account and any keys that were deactivated because payment lapsed.
+// keys the user turned off themselves stay off, and accounts an admin turned off stay off whatever stripe says
+func activateCustomer(ctx context.Context, customerID string) error {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.M{"stripe_customer_id": customerID}).Decode(&user); err != nil {
+		return err
+	}
+
+	if !user.IsActive && user.DisabledReason != paymentLapsed {
+		log.Println("Not activating user " + user.ID.Hex() + ", the account was disabled for " + user.DisabledReason)
+		return nil
+	}
+
+	_, err := userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"is_active": true}, "$unset": bson.M{"disabled_reason": ""}})
+	if err != nil {
+		return err
+	}
+
+	_, err = keyCollection.UpdateMany(ctx,
+		bson.M{"user": user.ID, "disabled_reason": paymentLapsed},
+		bson.M{"$set": bson.M{"is_active": true}, "$unset": bson.M{"disabled_reason": ""}},
+	)
+	return err
+}
+
+// deactivates the customer's account and all of their active keys. an account an admin disabled keeps that reason
+func deactivateCustomer(ctx context.Context, customerID string) error {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.M{"stripe_customer_id": customerID}).Decode(&user); err != nil {
+		return err
+	}
+
+	if user.DisabledReason != models.DisabledAdmin {
+		_, err := userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"is_active": false, "disabled_reason": paymentLapsed}})
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := keyCollection.UpdateMany(ctx,
+		bson.M{"user": user.ID, "is_active": true},
+		bson.M{"$set": bson.M{"is_active": false, "disabled_reason": paymentLapsed}},
+	)
+	return err
+}
@@ -0,0 +1,78 @@
+package billing
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"vehicle-api/configs"
+
+	"github.com/stripe/stripe-go/v74"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var callCollection *mongo.Collection = configs.GetCollection(configs.DB, "calls")
+var userCollection *mongo.Collection = configs.GetCollection(configs.DB, "users")
+var usageReportCollection *mongo.Collection = configs.GetCollection(configs.DB, "usage_reports")
+
+// routes that are billed per call, autofill routes are covered by the flat subscription. comparables run the
+// same listing search as a valuation so they are billed like one
+var billableRoutes = []string{"valuation", "valuation_comparables"}
+
+const defaultInterval = 10 * time.Minute
+
+// sets the stripe key and, if STRIPE_API_BASE is set, points the client at it (e.g. http://localhost:12111 for stripe-mock)
+func setupStripe() {
+	stripe.Key = configs.RetrieveEnv("STRIPE_SECRET_KEY")
+
+	if apiBase := configs.RetrieveEnv("STRIPE_API_BASE"); apiBase != "" {
+		stripe.SetBackend(stripe.APIBackend, stripe.GetBackendWithConfig(stripe.APIBackend, &stripe.BackendConfig{
+			URL:        stripe.String(apiBase),
+			HTTPClient: &http.Client{Timeout: 30 * time.Second},
+		}))
+	}
+}
+
+// starts reporting usage to stripe every BILLING_INTERVAL (default 10m). does nothing without a stripe key
+func Start() {
+	if configs.RetrieveEnv("STRIPE_SECRET_KEY") == "" {
+		log.Println("STRIPE_SECRET_KEY is not set, usage will not be reported")
+		return
+	}
+
+	setupStripe()
+
+	interval := defaultInterval
+	if value := configs.RetrieveEnv("BILLING_INTERVAL"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			log.Println("Invalid BILLING_INTERVAL, using", defaultInterval, err)
+		} else {
+			interval = parsed
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			RunOnce()
+			<-ticker.C
+		}
+	}()
+}
+
+// batches unbilled calls and sends every report that is due
+func RunOnce() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := createUsageReports(ctx); err != nil {
+		log.Println("Error creating usage reports:", err)
+	}
+
+	if err := sendUsageReports(ctx); err != nil {
+		log.Println("Error sending usage reports:", err)
+	}
+}
@@ -0,0 +1,137 @@
+package billing
+
+import (
+	"context"
+	"vehicle-api/models"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/usagerecordsummary"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type Reconciliation struct {
+	User             primitive.ObjectID `json:"user"`
+	SubscriptionItem string             `json:"subscription_item"`
+	BillableCalls    int64              `json:"billable_calls"`
+	UnbatchedCalls   int64              `json:"unbatched_calls"`
+	PendingQuantity  int64              `json:"pending_quantity"`
+	FailedQuantity   int64              `json:"failed_quantity"`
+	ReportedQuantity int64              `json:"reported_quantity"`
+	StripeUsage      *int64             `json:"stripe_usage,omitempty"`
+	Mismatch         bool               `json:"mismatch"`
+}
+
+// compares billable calls made between from and to with what was reported for them.
+// with checkStripe the reported total is also compared with stripe's usage summaries,
+// which only lines up when from and to match the subscription's billing period
+func Reconcile(ctx context.Context, from int64, to int64, checkStripe bool) ([]Reconciliation, error) {
+	if checkStripe {
+		setupStripe()
+	}
+
+	filter := billableFilter()
+	filter["created_at"] = bson.M{"$gte": from, "$lte": to}
+
+	//count calls per user by the status of the report they were batched into
+	cursor, err := callCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$lookup": bson.M{
+			"from":         "usage_reports",
+			"localField":   "usage_report",
+			"foreignField": "_id",
+			"as":           "report",
+		}},
+		bson.M{"$group": bson.M{
+			"_id":   bson.M{"user": "$user", "status": bson.M{"$arrayElemAt": bson.A{"$report.status", 0}}},
+			"count": bson.M{"$sum": 1},
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var counts []struct {
+		ID struct {
+			User   primitive.ObjectID `bson:"user"`
+			Status string             `bson:"status"`
+		} `bson:"_id"`
+		Count int64 `bson:"count"`
+	}
+	if err := cursor.All(ctx, &counts); err != nil {
+		return nil, err
+	}
+
+	byUser := map[primitive.ObjectID]*Reconciliation{}
+	order := []primitive.ObjectID{}
+
+	for _, count := range counts {
+		reconciliation, ok := byUser[count.ID.User]
+		if !ok {
+			reconciliation = &Reconciliation{User: count.ID.User}
+			byUser[count.ID.User] = reconciliation
+			order = append(order, count.ID.User)
+		}
+
+		reconciliation.BillableCalls += count.Count
+
+		switch count.ID.Status {
+		case models.UsageReportReported:
+			reconciliation.ReportedQuantity += count.Count
+		case models.UsageReportPending:
+			reconciliation.PendingQuantity += count.Count
+		case models.UsageReportFailed:
+			reconciliation.FailedQuantity += count.Count
+		default:
+			reconciliation.UnbatchedCalls += count.Count
+		}
+	}
+
+	results := []Reconciliation{}
+
+	for _, userID := range order {
+		reconciliation := byUser[userID]
+
+		var user models.User
+		if err := userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user); err == nil {
+			reconciliation.SubscriptionItem = user.ValuationItemID
+		}
+
+		//failed reports and calls that were never batched for a subscribed user are lost revenue
+		reconciliation.Mismatch = reconciliation.FailedQuantity > 0 || (reconciliation.UnbatchedCalls > 0 && reconciliation.SubscriptionItem != "")
+
+		if checkStripe && reconciliation.SubscriptionItem != "" {
+			usage, err := stripeUsage(reconciliation.SubscriptionItem, from, to)
+			if err != nil {
+				return nil, err
+			}
+
+			reconciliation.StripeUsage = &usage
+			if usage != reconciliation.ReportedQuantity {
+				reconciliation.Mismatch = true
+			}
+		}
+
+		results = append(results, *reconciliation)
+	}
+
+	return results, nil
+}
+
+// totals stripe's usage summaries for periods that fall within from and to
+func stripeUsage(subscriptionItem string, from int64, to int64) (int64, error) {
+	params := &stripe.UsageRecordSummaryListParams{
+		SubscriptionItem: stripe.String(subscriptionItem),
+	}
+
+	var total int64
+	iter := usagerecordsummary.List(params)
+	for iter.Next() {
+		summary := iter.UsageRecordSummary()
+		if summary.Period != nil && summary.Period.Start >= from && summary.Period.Start <= to {
+			total += summary.TotalUsage
+		}
+	}
+
+	return total, iter.Err()
+}
@@ -0,0 +1,211 @@
+package billing
+
+import (
+	"context"
+	"log"
+	"math"
+	"net/http"
+	"time"
+	"vehicle-api/models"
+
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/usagerecord"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const maxAttempts = 10
+
+// only successful calls are billed
+func billableFilter() bson.M {
+	return bson.M{
+		"route":           bson.M{"$in": billableRoutes},
+		"response_status": http.StatusOK,
+	}
+}
+
+// groups every billable call that isn't in a report yet into one pending report per user and route
+func createUsageReports(ctx context.Context) error {
+	//leave the last minute alone so calls still being logged land in the next batch
+	cutoff := time.Now().Add(-time.Minute).Unix()
+
+	filter := billableFilter()
+	filter["usage_report"] = bson.M{"$exists": false}
+	filter["created_at"] = bson.M{"$lte": cutoff}
+
+	cursor, err := callCollection.Aggregate(ctx, bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$group": bson.M{"_id": bson.M{"user": "$user", "route": "$route"}}},
+	})
+	if err != nil {
+		return err
+	}
+
+	var groups []struct {
+		ID struct {
+			User  primitive.ObjectID `bson:"user"`
+			Route string             `bson:"route"`
+		} `bson:"_id"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return err
+	}
+
+	for _, group := range groups {
+		var user models.User
+		if err := userCollection.FindOne(ctx, bson.M{"_id": group.ID.User}).Decode(&user); err != nil {
+			log.Println("Error finding user "+group.ID.User.Hex()+" for billing:", err)
+			continue
+		}
+
+		//calls stay unbilled until the user has a metered subscription
+		if user.ValuationItemID == "" {
+			continue
+		}
+
+		report := models.UsageReport{
+			User:               user.ID,
+			SubscriptionItemID: user.ValuationItemID,
+			Route:              group.ID.Route,
+			Status:             models.UsageReportPending,
+			CreatedAt:          time.Now().Unix(),
+		}
+
+		result, err := usageReportCollection.InsertOne(ctx, report)
+		if err != nil {
+			log.Println("Error creating usage report:", err)
+			continue
+		}
+		report.ID = result.InsertedID.(primitive.ObjectID)
+
+		//claim the calls, only calls without a report are updated so each call is billed once
+		//even if another instance is batching at the same time
+		callFilter := billableFilter()
+		callFilter["user"] = user.ID
+		callFilter["route"] = group.ID.Route
+		callFilter["usage_report"] = bson.M{"$exists": false}
+		callFilter["created_at"] = bson.M{"$lte": cutoff}
+
+		updated, err := callCollection.UpdateMany(ctx, callFilter, bson.M{"$set": bson.M{"usage_report": report.ID}})
+		if err != nil {
+			log.Println("Error assigning calls to usage report:", err)
+			usageReportCollection.DeleteOne(ctx, bson.M{"_id": report.ID})
+			continue
+		}
+
+		if updated.ModifiedCount == 0 {
+			usageReportCollection.DeleteOne(ctx, bson.M{"_id": report.ID})
+		}
+	}
+
+	return nil
+}
+
+// sends pending reports to stripe, failed sends are retried with exponential backoff
+func sendUsageReports(ctx context.Context) error {
+	now := time.Now().Unix()
+
+	//reports younger than a minute may still be claiming calls on another instance
+	filter := bson.M{
+		"status":          models.UsageReportPending,
+		"next_attempt_at": bson.M{"$lte": now},
+		"created_at":      bson.M{"$lte": now - 60},
+	}
+
+	cursor, err := usageReportCollection.Find(ctx, filter, options.Find().SetSort(bson.M{"created_at": 1}))
+	if err != nil {
+		return err
+	}
+
+	var reports []models.UsageReport
+	if err := cursor.All(ctx, &reports); err != nil {
+		return err
+	}
+
+	for _, report := range reports {
+		sendUsageReport(ctx, report)
+	}
+
+	return nil
+}
+
+func sendUsageReport(ctx context.Context, report models.UsageReport) {
+	//the quantity is always counted from the claimed calls so a crash while batching can't bill the wrong amount
+	quantity, err := callCollection.CountDocuments(ctx, bson.M{"usage_report": report.ID})
+	if err != nil {
+		log.Println("Error counting calls for usage report "+report.ID.Hex()+":", err)
+		return
+	}
+
+	if quantity == 0 {
+		usageReportCollection.DeleteOne(ctx, bson.M{"_id": report.ID})
+		return
+	}
+
+	if quantity != report.Quantity {
+		report.Quantity = quantity
+		if _, err := usageReportCollection.UpdateOne(ctx, bson.M{"_id": report.ID}, bson.M{"$set": bson.M{"quantity": quantity}}); err != nil {
+			log.Println("Error setting usage report quantity:", err)
+			return
+		}
+	}
+
+	params := &stripe.UsageRecordParams{
+		SubscriptionItem: stripe.String(report.SubscriptionItemID),
+		Quantity:         stripe.Int64(report.Quantity),
+		Action:           stripe.String(string(stripe.UsageRecordActionIncrement)),
+		TimestampNow:     stripe.Bool(true),
+	}
+	params.SetIdempotencyKey("usage-report-" + report.ID.Hex())
+
+	record, err := usagerecord.New(params)
+
+	if err != nil {
+		attempts := report.Attempts + 1
+		update := bson.M{
+			"attempts":        attempts,
+			"last_error":      err.Error(),
+			"next_attempt_at": time.Now().Add(time.Duration(math.Pow(2, float64(attempts))) * time.Minute).Unix(),
+		}
+
+		if attempts >= maxAttempts {
+			update["status"] = models.UsageReportFailed
+			log.Println("Giving up on usage report "+report.ID.Hex()+":", err)
+		}
+
+		if _, err := usageReportCollection.UpdateOne(ctx, bson.M{"_id": report.ID}, bson.M{"$set": update}); err != nil {
+			log.Println("Error updating usage report:", err)
+		}
+		return
+	}
+
+	_, err = usageReportCollection.UpdateOne(ctx, bson.M{"_id": report.ID}, bson.M{"$set": bson.M{
+		"status":          models.UsageReportReported,
+		"attempts":        report.Attempts + 1,
+		"stripe_usage_id": record.ID,
+		"reported_at":     time.Now().Unix(),
+	}})
+	if err != nil {
+		//the idempotency key makes the retry safe
+		log.Println("Error marking usage report "+report.ID.Hex()+" as reported:", err)
+	}
+}
+
+// puts a failed report back in the queue
+func RetryUsageReport(ctx context.Context, reportID primitive.ObjectID) error {
+	result, err := usageReportCollection.UpdateOne(ctx,
+		bson.M{"_id": reportID, "status": models.UsageReportFailed},
+		bson.M{"$set": bson.M{"status": models.UsageReportPending, "attempts": 0, "next_attempt_at": 0}},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.MatchedCount == 0 {
+		return mongo.ErrNoDocuments
+	}
+
+	return nil
+}
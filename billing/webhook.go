@@ -0,0 +1,191 @@
+package billing
+
+import (
+	"context"
+	"log"
+	"time"
+	"vehicle-api/configs"
+
+	"github.com/goccy/go-json"
+	"github.com/stripe/stripe-go/v74"
+	"github.com/stripe/stripe-go/v74/customer"
+	"github.com/stripe/stripe-go/v74/webhook"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var stripeEventCollection *mongo.Collection = configs.GetCollection(configs.DB, "stripe_events")
+
+// verifies the Stripe-Signature header against STRIPE_WEBHOOK_SECRET and parses the event
+func ConstructEvent(payload []byte, signature string) (stripe.Event, error) {
+	return webhook.ConstructEventWithOptions(payload, signature, configs.RetrieveEnv("STRIPE_WEBHOOK_SECRET"), webhook.ConstructEventOptions{
+		//the account's api version can be newer than the library's, we only read fields that exist in both
+		IgnoreAPIVersionMismatch: true,
+	})
+}
+
+// records the event id, returns false if the event has already been handled since stripe can deliver an event more than once
+func markEventHandled(ctx context.Context, event stripe.Event) (bool, error) {
+	_, err := stripeEventCollection.InsertOne(ctx, bson.M{"_id": event.ID, "type": event.Type, "created_at": time.Now().Unix()})
+	if mongo.IsDuplicateKeyError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// updates users and keys for subscription and payment lifecycle events, unknown events are ignored
+func HandleEvent(ctx context.Context, event stripe.Event) error {
+	isNew, err := markEventHandled(ctx, event)
+	if err != nil || !isNew {
+		return err
+	}
+
+	err = handleEvent(ctx, event)
+	if err != nil {
+		//let stripe retry the event
+		stripeEventCollection.DeleteOne(ctx, bson.M{"_id": event.ID})
+	}
+	return err
+}
+
+func handleEvent(ctx context.Context, event stripe.Event) error {
+	switch event.Type {
+	case "setup_intent.succeeded":
+		var setupIntent stripe.SetupIntent
+		if err := json.Unmarshal(event.Data.Raw, &setupIntent); err != nil {
+			return err
+		}
+		return setupIntentSucceeded(ctx, setupIntent)
+
+	case "customer.subscription.created", "customer.subscription.updated":
+		var subscription stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &subscription); err != nil {
+			return err
+		}
+		return subscriptionUpdated(ctx, subscription)
+
+	case "customer.subscription.deleted":
+		var subscription stripe.Subscription
+		if err := json.Unmarshal(event.Data.Raw, &subscription); err != nil {
+			return err
+		}
+		return subscriptionDeleted(ctx, subscription)
+
+	case "invoice.paid":
+		var invoice stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			return err
+		}
+		if invoice.Customer == nil {
+			return nil
+		}
+		return activateCustomer(ctx, invoice.Customer.ID)
+
+	case "invoice.payment_failed":
+		var invoice stripe.Invoice
+		if err := json.Unmarshal(event.Data.Raw, &invoice); err != nil {
+			return err
+		}
+		if invoice.Customer == nil {
+			return nil
+		}
+		//stripe keeps retrying the payment, only deactivate once there are no retries left
+		if invoice.NextPaymentAttempt == 0 {
+			return deactivateCustomer(ctx, invoice.Customer.ID)
+		}
+		log.Println("Payment failed for customer " + invoice.Customer.ID + ", stripe will retry")
+	}
+
+	return nil
+}
+
+// saves the card and makes it the customer's default so invoices can be paid with it
+func setupIntentSucceeded(ctx context.Context, setupIntent stripe.SetupIntent) error {
+	if setupIntent.Customer == nil || setupIntent.PaymentMethod == nil {
+		return nil
+	}
+
+	_, err := userCollection.UpdateOne(ctx,
+		bson.M{"stripe_customer_id": setupIntent.Customer.ID},
+		bson.M{"$set": bson.M{"payment_method_id": setupIntent.PaymentMethod.ID, "setup_intent_id": ""}},
+	)
+	if err != nil {
+		return err
+	}
+
+	setupStripe()
+
+	_, err = customer.Update(setupIntent.Customer.ID, &stripe.CustomerParams{
+		InvoiceSettings: &stripe.CustomerInvoiceSettingsParams{
+			DefaultPaymentMethod: stripe.String(setupIntent.PaymentMethod.ID),
+		},
+	})
+	return err
+}
+
+// stores the subscription and item ids and activates or deactivates the account from the subscription status
+func subscriptionUpdated(ctx context.Context, subscription stripe.Subscription) error {
+	if subscription.Customer == nil {
+		return nil
+	}
+
+	update := bson.M{}
+	if subscription.Items != nil {
+		for _, item := range subscription.Items.Data {
+			if item.Price == nil {
+				continue
+			}
+			switch item.Price.ID {
+			case configs.RetrieveEnv("AUTOCOMPLETE_PRICE_ID"):
+				update["autofill_subscription_id"] = subscription.ID
+			case configs.RetrieveEnv("VEHICLE_VIN_DATA_PRICE_ID"):
+				update["valuation_item_id"] = item.ID
+			}
+		}
+	}
+
+	if len(update) > 0 {
+		if _, err := userCollection.UpdateOne(ctx, bson.M{"stripe_customer_id": subscription.Customer.ID}, bson.M{"$set": update}); err != nil {
+			return err
+		}
+	}
+
+	switch subscription.Status {
+	case stripe.SubscriptionStatusActive, stripe.SubscriptionStatusTrialing:
+		return activateCustomer(ctx, subscription.Customer.ID)
+	case stripe.SubscriptionStatusUnpaid, stripe.SubscriptionStatusCanceled, stripe.SubscriptionStatusIncompleteExpired, stripe.SubscriptionStatusPaused:
+		return deactivateCustomer(ctx, subscription.Customer.ID)
+	}
+
+	return nil
+}
+
+func subscriptionDeleted(ctx context.Context, subscription stripe.Subscription) error {
+	if subscription.Customer == nil {
+		return nil
+	}
+
+	//only clear the ids that belong to this subscription
+	unset := bson.M{}
+	if subscription.Items != nil {
+		for _, item := range subscription.Items.Data {
+			if item.Price == nil {
+				continue
+			}
+			switch item.Price.ID {
+			case configs.RetrieveEnv("AUTOCOMPLETE_PRICE_ID"):
+				unset["autofill_subscription_id"] = ""
+			case configs.RetrieveEnv("VEHICLE_VIN_DATA_PRICE_ID"):
+				unset["valuation_item_id"] = ""
+			}
+		}
+	}
+
+	if len(unset) > 0 {
+		if _, err := userCollection.UpdateOne(ctx, bson.M{"stripe_customer_id": subscription.Customer.ID}, bson.M{"$unset": unset}); err != nil {
+			return err
+		}
+	}
+
+	return deactivateCustomer(ctx, subscription.Customer.ID)
+}
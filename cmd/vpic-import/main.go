@@ -0,0 +1,317 @@
+// Command vpic-import loads a trimmed export of NHTSA's vPIC pattern tables into Mongo so VINs can be decoded
+// without calling the vPIC api.
+//
+// The export is a CSV file with a wmi,year_from,year_to,keys,element,value header, or a JSON file holding either
+// an array or one object per line with the same fields. Keys is the vPIC pattern for positions 4-8 and 10-17 of
+// the VIN, e.g. "CM826|*A".
+//
+//	go run ./cmd/vpic-import -file patterns.csv -replace
+//	go run ./cmd/vpic-import -check vins.txt
+//
+// -check decodes every VIN in a file (one per line) locally and with the vPIC api and prints the differences,
+// which is useful before switching VIN_DECODER to local.
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var patternFields = []string{"wmi", "year_from", "year_to", "keys", "element", "value"}
+
+func main() {
+	file := flag.String("file", "", "vPIC pattern export to import (.csv, .json or .jsonl)")
+	replace := flag.Bool("replace", false, "replace the existing patterns once the whole file has been imported")
+	batchSize := flag.Int("batch", 1000, "number of patterns inserted at a time")
+	check := flag.String("check", "", "file of VINs to decode locally and remotely and compare")
+	flag.Parse()
+
+	if *file == "" && *check == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	collection := configs.GetCollection(configs.DB, "vpic_patterns")
+
+	if *file != "" {
+		count, err := importPatterns(collection, *file, *replace, *batchSize)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Println("Imported", count, "patterns")
+	}
+
+	if *check != "" {
+		if err := checkVins(*check); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func importPatterns(collection *mongo.Collection, path string, replace bool, batchSize int) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var next func() (models.VinPattern, error)
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".csv":
+		next, err = csvPatterns(f)
+	case ".json", ".jsonl":
+		next, err = jsonPatterns(f)
+	default:
+		return 0, errors.New("unsupported file type " + filepath.Ext(path) + ", use .csv, .json or .jsonl")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	ctx := context.Background()
+
+	//replacements are imported next to the live patterns and swapped in at the end, so local decoding keeps
+	//working during the import and a failed import leaves the old patterns alone
+	target := collection
+	if replace {
+		target = collection.Database().Collection(collection.Name() + "_staging")
+		if err := target.Drop(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	count := 0
+	batch := make([]interface{}, 0, batchSize)
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		insertCtx, cancel := context.WithTimeout(ctx, time.Minute)
+		defer cancel()
+		if _, err := target.InsertMany(insertCtx, batch); err != nil {
+			return err
+		}
+		count += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	for line := 1; ; line++ {
+		pattern, err := next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return count, fmt.Errorf("pattern %d: %w", line, err)
+		}
+
+		if err := validatePattern(pattern); err != nil {
+			return count, fmt.Errorf("pattern %d: %w", line, err)
+		}
+
+		batch = append(batch, pattern)
+		if len(batch) == batchSize {
+			if err := flush(); err != nil {
+				return count, err
+			}
+		}
+	}
+
+	if err := flush(); err != nil {
+		return count, err
+	}
+
+	if replace {
+		return count, swapPatterns(ctx, target, collection)
+	}
+	return count, nil
+}
+
+// indexes the staging collection like the live one then renames it over the live patterns in one step
+func swapPatterns(ctx context.Context, staging *mongo.Collection, collection *mongo.Collection) error {
+	_, err := staging.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys: bson.D{{Key: "wmi", Value: 1}, {Key: "year_from", Value: 1}},
+	})
+	if err != nil {
+		return err
+	}
+
+	database := collection.Database().Name()
+	return collection.Database().Client().Database("admin").RunCommand(ctx, bson.D{
+		{Key: "renameCollection", Value: database + "." + staging.Name()},
+		{Key: "to", Value: database + "." + collection.Name()},
+		{Key: "dropTarget", Value: true},
+	}).Err()
+}
+
+func validatePattern(pattern models.VinPattern) error {
+	if len(pattern.WMI) != 3 && len(pattern.WMI) != 6 {
+		return errors.New("wmi must be 3 characters, or 6 for small manufacturers")
+	}
+	if pattern.Keys == "" || pattern.Element == "" {
+		return errors.New("keys and element are required")
+	}
+	if pattern.YearTo != 0 && pattern.YearTo < pattern.YearFrom {
+		return errors.New("year_to is before year_from")
+	}
+	return nil
+}
+
+func csvPatterns(r io.Reader) (func() (models.VinPattern, error), error) {
+	reader := csv.NewReader(r)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	columns := map[string]int{}
+	for i, name := range header {
+		columns[strings.TrimSpace(strings.ToLower(name))] = i
+	}
+	for _, field := range patternFields {
+		if _, ok := columns[field]; !ok {
+			return nil, errors.New("csv is missing the " + field + " column")
+		}
+	}
+
+	return func() (models.VinPattern, error) {
+		record, err := reader.Read()
+		if err != nil {
+			return models.VinPattern{}, err
+		}
+
+		yearFrom, err := parseYear(record[columns["year_from"]])
+		if err != nil {
+			return models.VinPattern{}, err
+		}
+		yearTo, err := parseYear(record[columns["year_to"]])
+		if err != nil {
+			return models.VinPattern{}, err
+		}
+
+		return models.VinPattern{
+			WMI:      strings.ToUpper(strings.TrimSpace(record[columns["wmi"]])),
+			YearFrom: yearFrom,
+			YearTo:   yearTo,
+			Keys:     strings.ToUpper(strings.TrimSpace(record[columns["keys"]])),
+			Element:  strings.TrimSpace(record[columns["element"]]),
+			Value:    strings.TrimSpace(record[columns["value"]]),
+		}, nil
+	}, nil
+}
+
+// an empty year means the range is open
+func parseYear(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// reads either a json array or one json object per line
+func jsonPatterns(r io.Reader) (func() (models.VinPattern, error), error) {
+	buffered := bufio.NewReader(r)
+
+	first, err := firstNonSpace(buffered)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(buffered)
+	if first == '[' {
+		if _, err := decoder.Token(); err != nil {
+			return nil, err
+		}
+	}
+
+	return func() (models.VinPattern, error) {
+		if first == '[' && !decoder.More() {
+			return models.VinPattern{}, io.EOF
+		}
+
+		var pattern models.VinPattern
+		if err := decoder.Decode(&pattern); err != nil {
+			return models.VinPattern{}, err
+		}
+
+		pattern.WMI = strings.ToUpper(strings.TrimSpace(pattern.WMI))
+		pattern.Keys = strings.ToUpper(strings.TrimSpace(pattern.Keys))
+		return pattern, nil
+	}, nil
+}
+
+func firstNonSpace(r *bufio.Reader) (byte, error) {
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\n' && b != '\r' && b != '\t' {
+			return b, r.UnreadByte()
+		}
+	}
+}
+
+func checkVins(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	matched, differed, failed := 0, 0, 0
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		vin := strings.ToUpper(strings.TrimSpace(scanner.Text()))
+		if vin == "" {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		local, localErr := utils.DecodeVinLocal(ctx, vin)
+		remote, remoteErr := utils.DecodeVinRemote(ctx, vin)
+		cancel()
+
+		switch {
+		case localErr != nil || remoteErr != nil:
+			failed++
+			fmt.Printf("%s: local error %v, remote error %v\n", vin, localErr, remoteErr)
+		default:
+			diffs := utils.DiffVehicleSpecs(local, remote)
+			if len(diffs) == 0 {
+				matched++
+				continue
+			}
+			differed++
+			for _, diff := range diffs {
+				fmt.Printf("%s: %s\n", vin, diff)
+			}
+		}
+	}
+
+	fmt.Printf("%d matched, %d differed, %d could not be decoded by both\n", matched, differed, failed)
+
+	return scanner.Err()
+}
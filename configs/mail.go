@@ -0,0 +1,23 @@
+package configs
+
+import "vehicle-api/mailer"
+
+// picks the mailer from MAIL_DRIVER: smtp, file (writes to MAIL_DIR) or log (default)
+func ConnectMailer() mailer.Mailer {
+	from := RetrieveEnv("MAIL_FROM")
+
+	switch RetrieveEnv("MAIL_DRIVER") {
+	case "smtp":
+		return mailer.NewSMTPMailer(RetrieveEnv("SMTP_HOST"), RetrieveEnv("SMTP_PORT"), RetrieveEnv("SMTP_USERNAME"), RetrieveEnv("SMTP_PASSWORD"), from)
+	case "file":
+		dir := RetrieveEnv("MAIL_DIR")
+		if dir == "" {
+			dir = "mail"
+		}
+		return mailer.NewFileMailer(dir, from)
+	}
+
+	return mailer.NewLogMailer()
+}
+
+var Mailer mailer.Mailer = ConnectMailer()
@@ -0,0 +1,82 @@
+package configs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const sessionKeyPrefix = "session:"
+const csrfKeyPrefix = "csrf:"
+
+// fiber.Storage backed by the shared redis client so sessions survive restarts and are shared between replicas.
+// every key is stored under prefix so sessions and csrf tokens can't collide or be reset together
+type RedisSessionStorage struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisSessionStorage(client *redis.Client) *RedisSessionStorage {
+	return NewRedisStorage(client, sessionKeyPrefix)
+}
+
+func NewRedisStorage(client *redis.Client, prefix string) *RedisSessionStorage {
+	return &RedisSessionStorage{client: client, prefix: prefix}
+}
+
+// returns nil, nil when the session doesn't exist
+func (s *RedisSessionStorage) Get(id string) ([]byte, error) {
+	if id == "" {
+		return nil, nil
+	}
+
+	val, err := s.client.Get(context.Background(), s.prefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+
+	return val, err
+}
+
+func (s *RedisSessionStorage) Set(id string, val []byte, exp time.Duration) error {
+	if id == "" || len(val) == 0 {
+		return nil
+	}
+
+	return s.client.Set(context.Background(), s.prefix+id, val, exp).Err()
+}
+
+func (s *RedisSessionStorage) Delete(id string) error {
+	if id == "" {
+		return nil
+	}
+
+	return s.client.Del(context.Background(), s.prefix+id).Err()
+}
+
+// checks if the session still exists without reading it
+func (s *RedisSessionStorage) Exists(id string) (bool, error) {
+	count, err := s.client.Exists(context.Background(), s.prefix+id).Result()
+	return count > 0, err
+}
+
+// deletes every key under the prefix, nothing else is removed since the client is shared
+func (s *RedisSessionStorage) Reset() error {
+	ctx := context.Background()
+	iter := s.client.Scan(ctx, 0, s.prefix+"*", 100).Iterator()
+
+	for iter.Next(ctx) {
+		if err := s.client.Del(ctx, iter.Val()).Err(); err != nil {
+			return err
+		}
+	}
+
+	return iter.Err()
+}
+
+// the redis client is shared with the rest of the app so it is left open
+func (s *RedisSessionStorage) Close() error {
+	return nil
+}
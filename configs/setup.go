@@ -12,8 +12,19 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+const SessionExpiration = 7 * 24 * time.Hour
+
+var SessionStorage = NewRedisSessionStorage(Redis)
+
+// csrf tokens are readable from js so they are kept apart from the session ids
+var CsrfStorage = NewRedisStorage(Redis, csrfKeyPrefix)
+
+// sessions only hold the user or admin id as a hex string, everything else is loaded from the database
 var sessions = session.New(session.Config{
-	Expiration: 7 * 24 * time.Hour,
+	Expiration:     SessionExpiration,
+	Storage:        SessionStorage,
+	CookieHTTPOnly: true,
+	CookieSameSite: "Lax",
 })
 
 func GetSession() *session.Store {
@@ -71,6 +82,63 @@ func ConnectDB() *mongo.Client {
 		log.Fatal(err)
 	}
 
+	//keys are looked up by prefix, legacy keys by the hash of the whole key
+	var keyCollection *mongo.Collection = GetCollection(client, "keys")
+
+	indexNames, err := keyCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{
+				Keys:    bson.D{{Key: "prefix", Value: 1}},
+				Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"prefix": bson.M{"$exists": true}}),
+			},
+			{
+				Keys: bson.D{{Key: "hash", Value: 1}},
+			},
+		},
+	)
+
+	log.Println(indexNames)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//calls are read back by user or key over a time range for usage analytics
+	var callCollection *mongo.Collection = GetCollection(client, "calls")
+
+	indexNames, err = callCollection.Indexes().CreateMany(
+		context.Background(),
+		[]mongo.IndexModel{
+			{Keys: bson.D{{Key: "user", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "key", Value: 1}, {Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "created_at", Value: -1}}},
+			{Keys: bson.D{{Key: "usage_report", Value: 1}}},
+		},
+	)
+
+	log.Println(indexNames)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	//vin patterns are looked up by wmi and model year when decoding locally
+	var vinPatternCollection *mongo.Collection = GetCollection(client, "vpic_patterns")
+
+	indexName, err = vinPatternCollection.Indexes().CreateOne(
+		context.Background(),
+		mongo.IndexModel{
+			Keys: bson.D{{Key: "wmi", Value: 1}, {Key: "year_from", Value: 1}},
+		},
+	)
+
+	log.Println(indexName)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	return client
 }
 
@@ -0,0 +1,305 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var adminCollection *mongo.Collection = configs.GetCollection(configs.DB, "admins")
+var callCollection *mongo.Collection = configs.GetCollection(configs.DB, "calls")
+
+func AdminLogin(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payload := struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}{}
+
+	//validate the request body
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if payload.Email == "" || payload.Password == "" {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Email and password are required"}})
+	}
+
+	//verify admin exists and password is correct, don't reveal which one failed
+	var admin models.Admin
+	if err := adminCollection.FindOne(ctx, bson.M{"email": payload.Email}).Decode(&admin); err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Email and password do not match"}})
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(admin.Password), []byte(payload.Password)); err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Email and password do not match"}})
+	}
+
+	//retrieve session from fiber
+	store, err := configs.GetSession().Get(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	//start a fresh session so an old session id can't be reused
+	if err := store.Regenerate(); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	store.Set("admin", admin.ID.Hex())
+	if err := store.Save(); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	admin.Password = ""
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": admin}})
+}
+
+func AdminLogout(c *fiber.Ctx) error {
+	store, err := configs.GetSession().Get(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	store.Destroy()
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Logged out"}})
+}
+
+// lists users, optionally filtered by a search over name and email and by is_active
+func ListUsers(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, limit := utils.GetPagination(c)
+
+	filter := bson.M{}
+
+	if search := c.Query("search"); search != "" {
+		pattern := primitive.Regex{Pattern: regexp.QuoteMeta(search), Options: "i"}
+		filter["$or"] = bson.A{
+			bson.M{"email": pattern},
+			bson.M{"first_name": pattern},
+			bson.M{"last_name": pattern},
+		}
+	}
+
+	switch c.Query("is_active") {
+	case "true":
+		filter["is_active"] = true
+	case "false":
+		filter["is_active"] = false
+	}
+
+	total, err := userCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	opts := options.Find().
+		SetProjection(bson.M{"password": 0, "reset_token": 0, "email_token": 0}).
+		SetSort(bson.M{"created_at": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := userCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	users := []models.User{}
+	if err = cursor.All(ctx, &users); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"users": users, "page": page, "limit": limit, "total": total}})
+}
+
+func GetUser(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid user id"}})
+	}
+
+	var user models.User
+	opts := options.FindOne().SetProjection(bson.M{"password": 0, "reset_token": 0, "email_token": 0})
+	if err := userCollection.FindOne(ctx, bson.M{"_id": userID}, opts).Decode(&user); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).JSON(utils.ApiResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User not found"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": user}})
+}
+
+// activates or deactivates a user. deactivating a user also deactivates all of their keys,
+// activating does not reactivate keys since some may have been revoked on purpose
+func UpdateUserStatus(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid user id"}})
+	}
+
+	payload := struct {
+		IsActive *bool `json:"is_active"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if payload.IsActive == nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "is_active is required"}})
+	}
+
+	//accounts turned off here stay off when stripe reports a payment
+	update := bson.M{"$set": bson.M{"is_active": false, "disabled_reason": models.DisabledAdmin}}
+	if *payload.IsActive {
+		update = bson.M{"$set": bson.M{"is_active": true}, "$unset": bson.M{"disabled_reason": ""}}
+	}
+
+	result, err := userCollection.UpdateOne(ctx, bson.M{"_id": userID}, update)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if result.MatchedCount == 0 {
+		return c.Status(http.StatusNotFound).JSON(utils.ApiResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "User not found"}})
+	}
+
+	if !*payload.IsActive {
+		if _, err := keyCollection.UpdateMany(ctx, bson.M{"user": userID}, bson.M{"$set": bson.M{"is_active": false}}); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "User updated", "is_active": *payload.IsActive}})
+}
+
+func ListUserKeys(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid user id"}})
+	}
+
+	cursor, err := keyCollection.Find(ctx, bson.M{"user": userID})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	keys := []models.Key{}
+	if err = cursor.All(ctx, &keys); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"keys": keys}})
+}
+
+// lists a user's calls newest first, optionally filtered to a single key
+func ListUserCalls(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	userID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid user id"}})
+	}
+
+	page, limit := utils.GetPagination(c)
+
+	filter := bson.M{"user": userID}
+
+	if keyParam := c.Query("key"); keyParam != "" {
+		keyID, err := primitive.ObjectIDFromHex(keyParam)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid key id"}})
+		}
+		filter["key"] = keyID
+	}
+
+	total, err := callCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := callCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	calls := []models.Call{}
+	if err = cursor.All(ctx, &calls); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"calls": calls, "page": page, "limit": limit, "total": total}})
+}
+
+// sets the per route limits for a key, routes left out of the payload go back to the default limits
+func UpdateKeyLimits(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	keyID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid key id"}})
+	}
+
+	payload := struct {
+		Limits map[string]models.Limit `json:"limits"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	//limits can be set for every limited route, including the valuation routes keys aren't scoped to directly
+	for route, limit := range payload.Limits {
+		if _, ok := models.DefaultLimits[route]; !ok {
+			return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid route: " + route}})
+		}
+		if limit.PerSecond < 0 || limit.PerMonth < 0 {
+			return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Limits cannot be negative"}})
+		}
+	}
+
+	var key models.Key
+	err = keyCollection.FindOneAndUpdate(ctx, bson.M{"_id": keyID}, bson.M{"$set": bson.M{"limits": payload.Limits}}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).JSON(utils.ApiResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Key not found"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": key}})
+}
@@ -0,0 +1,266 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// date formats used to bucket calls, created_at is stored as unix seconds
+var usageIntervals = map[string]string{
+	"hour":  "%Y-%m-%dT%H:00:00Z",
+	"day":   "%Y-%m-%d",
+	"month": "%Y-%m",
+}
+
+// fields calls can be broken down by
+var usageGroups = map[string]string{
+	"key":    "$key",
+	"route":  "$route",
+	"status": "$response_status",
+}
+
+type usageBucket struct {
+	Period string      `json:"period"`
+	Group  interface{} `json:"group,omitempty"`
+	Count  int         `json:"count"`
+	P50    int         `json:"p50_response_time"`
+	P95    int         `json:"p95_response_time"`
+}
+
+type usageQuery struct {
+	From     int64
+	To       int64
+	Interval string
+	GroupBy  string
+}
+
+// reads from, to, interval and group_by. defaults to the last 30 days bucketed by day
+func parseUsageQuery(c *fiber.Ctx) (usageQuery, error) {
+	now := time.Now()
+	query := usageQuery{
+		From:     now.AddDate(0, 0, -30).Unix(),
+		To:       now.Unix(),
+		Interval: "day",
+		GroupBy:  c.Query("group_by"),
+	}
+
+	if from := c.Query("from"); from != "" {
+		parsed, err := strconv.ParseInt(from, 10, 64)
+		if err != nil {
+			return query, errors.New("from must be a unix timestamp")
+		}
+		query.From = parsed
+	}
+
+	if to := c.Query("to"); to != "" {
+		parsed, err := strconv.ParseInt(to, 10, 64)
+		if err != nil {
+			return query, errors.New("to must be a unix timestamp")
+		}
+		query.To = parsed
+	}
+
+	if query.From > query.To {
+		return query, errors.New("from must be before to")
+	}
+
+	if interval := c.Query("interval"); interval != "" {
+		if _, ok := usageIntervals[interval]; !ok {
+			return query, errors.New("interval must be hour, day or month")
+		}
+		query.Interval = interval
+	}
+
+	if _, ok := usageGroups[query.GroupBy]; query.GroupBy != "" && !ok {
+		return query, errors.New("group_by must be key, route or status")
+	}
+
+	return query, nil
+}
+
+// a range of response times and how many calls took that long
+type latencyBin struct {
+	Time  int `bson:"time"`
+	Count int `bson:"count"`
+}
+
+// response times rounded down to 1ms under 100ms, 10ms under 1s, 100ms under 10s and 1s above that, so a
+// bucket has a few hundred bins at most however many calls it has and percentiles are within 10%
+func latencyBinExpression() bson.M {
+	roundDown := func(width int) bson.M {
+		return bson.M{"$toInt": bson.M{"$multiply": bson.A{bson.M{"$floor": bson.M{"$divide": bson.A{"$response_time", width}}}, width}}}
+	}
+
+	return bson.M{"$switch": bson.M{
+		"branches": bson.A{
+			bson.M{"case": bson.M{"$lt": bson.A{"$response_time", 100}}, "then": "$response_time"},
+			bson.M{"case": bson.M{"$lt": bson.A{"$response_time", 1000}}, "then": roundDown(10)},
+			bson.M{"case": bson.M{"$lt": bson.A{"$response_time", 10000}}, "then": roundDown(100)},
+		},
+		"default": roundDown(1000),
+	}}
+}
+
+// returns the value at percentile p of bins sorted by time using nearest rank
+func percentile(bins []latencyBin, total int, p float64) int {
+	if len(bins) == 0 || total == 0 {
+		return 0
+	}
+
+	rank := int(math.Ceil(p / 100 * float64(total)))
+	if rank < 1 {
+		rank = 1
+	}
+
+	seen := 0
+	for _, bin := range bins {
+		seen += bin.Count
+		if seen >= rank {
+			return bin.Time
+		}
+	}
+	return bins[len(bins)-1].Time
+}
+
+// aggregates calls matching the filter into time buckets, optionally broken down by key, route or status.
+// response times are counted into bins rather than collected so a busy key can't hit mongo's memory limits,
+// percentiles are worked out here from the bins since $percentile needs mongo 7
+func aggregateUsage(ctx context.Context, filter bson.M, query usageQuery) ([]usageBucket, error) {
+	filter["created_at"] = bson.M{"$gte": query.From, "$lte": query.To}
+
+	binID := bson.M{
+		"period": bson.M{"$dateToString": bson.M{
+			"format": usageIntervals[query.Interval],
+			"date":   bson.M{"$toDate": bson.M{"$multiply": bson.A{"$created_at", 1000}}},
+		}},
+		"bin": latencyBinExpression(),
+	}
+	groupID := bson.M{"period": "$_id.period"}
+	if query.GroupBy != "" {
+		binID["group"] = usageGroups[query.GroupBy]
+		groupID["group"] = "$_id.group"
+	}
+
+	pipeline := bson.A{
+		bson.M{"$match": filter},
+		bson.M{"$group": bson.M{
+			"_id":   binID,
+			"count": bson.M{"$sum": 1},
+		}},
+		bson.M{"$group": bson.M{
+			"_id":   groupID,
+			"count": bson.M{"$sum": "$count"},
+			"bins":  bson.M{"$push": bson.M{"time": "$_id.bin", "count": "$count"}},
+		}},
+		bson.M{"$sort": bson.D{{Key: "_id.period", Value: 1}, {Key: "_id.group", Value: 1}}},
+	}
+
+	cursor, err := callCollection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []struct {
+		ID struct {
+			Period string      `bson:"period"`
+			Group  interface{} `bson:"group"`
+		} `bson:"_id"`
+		Count int          `bson:"count"`
+		Bins  []latencyBin `bson:"bins"`
+	}
+
+	if err = cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+
+	buckets := make([]usageBucket, len(results))
+	for i, result := range results {
+		sort.Slice(result.Bins, func(a, b int) bool { return result.Bins[a].Time < result.Bins[b].Time })
+		buckets[i] = usageBucket{
+			Period: result.ID.Period,
+			Group:  result.ID.Group,
+			Count:  result.Count,
+			P50:    percentile(result.Bins, result.Count, 50),
+			P95:    percentile(result.Bins, result.Count, 95),
+		}
+	}
+
+	return buckets, nil
+}
+
+// usage for the logged in user's keys
+func GetUsage(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	user := c.Locals("user").(models.User)
+
+	query, err := parseUsageQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	filter := bson.M{"user": user.ID}
+
+	if keyParam := c.Query("key"); keyParam != "" {
+		keyID, err := primitive.ObjectIDFromHex(keyParam)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid key id"}})
+		}
+		filter["key"] = keyID
+	}
+
+	buckets, err := aggregateUsage(ctx, filter, query)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"from": query.From, "to": query.To, "interval": query.Interval, "group_by": query.GroupBy, "usage": buckets}})
+}
+
+// usage across all users, optionally narrowed to one user or key
+func GetAdminUsage(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	query, err := parseUsageQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	filter := bson.M{}
+
+	if userParam := c.Query("user"); userParam != "" {
+		userID, err := primitive.ObjectIDFromHex(userParam)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid user id"}})
+		}
+		filter["user"] = userID
+	}
+
+	if keyParam := c.Query("key"); keyParam != "" {
+		keyID, err := primitive.ObjectIDFromHex(keyParam)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid key id"}})
+		}
+		filter["key"] = keyID
+	}
+
+	buckets, err := aggregateUsage(ctx, filter, query)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"from": query.From, "to": query.To, "interval": query.Interval, "group_by": query.GroupBy, "usage": buckets}})
+}
@@ -0,0 +1,105 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"vehicle-api/billing"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var usageReportCollection *mongo.Collection = configs.GetCollection(configs.DB, "usage_reports")
+
+func ListUsageReports(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	page, limit := utils.GetPagination(c)
+
+	filter := bson.M{}
+	if status := c.Query("status"); status != "" {
+		filter["status"] = status
+	}
+
+	if userParam := c.Query("user"); userParam != "" {
+		userID, err := primitive.ObjectIDFromHex(userParam)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid user id"}})
+		}
+		filter["user"] = userID
+	}
+
+	total, err := usageReportCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	opts := options.Find().
+		SetSort(bson.M{"created_at": -1}).
+		SetSkip((page - 1) * limit).
+		SetLimit(limit)
+
+	cursor, err := usageReportCollection.Find(ctx, filter, opts)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	reports := []models.UsageReport{}
+	if err = cursor.All(ctx, &reports); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"reports": reports, "page": page, "limit": limit, "total": total}})
+}
+
+func RetryUsageReport(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	reportID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid report id"}})
+	}
+
+	if err := billing.RetryUsageReport(ctx, reportID); err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).JSON(utils.ApiResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "No failed report found"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Report queued for retry"}})
+}
+
+// compares billable calls with reported usage, pass stripe=true to also check stripe's usage summaries
+func ReconcileUsage(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+	defer cancel()
+
+	query, err := parseUsageQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	results, err := billing.Reconcile(ctx, query.From, query.To, c.Query("stripe") == "true")
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	mismatches := 0
+	for _, result := range results {
+		if result.Mismatch {
+			mismatches++
+		}
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"from": query.From, "to": query.To, "mismatches": mismatches, "users": results}})
+}
@@ -0,0 +1,236 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/exp/slices"
+)
+
+type keyPayload struct {
+	Name              *string   `json:"name"`
+	Routes            *[]string `json:"routes"`
+	AuthorizedDomains *[]string `json:"authorized_domains"`
+	IsActive          *bool     `json:"is_active"`
+}
+
+// verifies every route is a known key route and removes duplicates
+func cleanKeyRoutes(routes []string) ([]string, error) {
+	if len(routes) == 0 {
+		return nil, errors.New("At least one route is required")
+	}
+
+	cleaned := []string{}
+	for _, route := range routes {
+		route = strings.ToLower(strings.TrimSpace(route))
+		if !slices.Contains(models.KeyRoutes, route) {
+			return nil, errors.New("Invalid route: " + route + ". Valid routes are " + strings.Join(models.KeyRoutes, ", "))
+		}
+		if !slices.Contains(cleaned, route) {
+			cleaned = append(cleaned, route)
+		}
+	}
+
+	return cleaned, nil
+}
+
+// normalizes domains to match what c.Hostname() returns in the key middlewares
+func cleanAuthorizedDomains(domains []string) []string {
+	cleaned := []string{}
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		domain = strings.TrimPrefix(strings.TrimPrefix(domain, "https://"), "http://")
+		domain = strings.TrimSuffix(domain, "/")
+		if domain != "" && !slices.Contains(cleaned, domain) {
+			cleaned = append(cleaned, domain)
+		}
+	}
+	return cleaned
+}
+
+func CreateKey(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user := c.Locals("user").(models.User)
+
+	if !user.IsVerified {
+		return c.Status(http.StatusForbidden).JSON(utils.ApiResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": "Please verify your email before creating keys"}})
+	}
+
+	var payload keyPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if payload.Routes == nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "At least one route is required"}})
+	}
+
+	routes, err := cleanKeyRoutes(*payload.Routes)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	authorizedDomains := []string{}
+	if payload.AuthorizedDomains != nil {
+		authorizedDomains = cleanAuthorizedDomains(*payload.AuthorizedDomains)
+	}
+
+	name := ""
+	if payload.Name != nil {
+		name = strings.TrimSpace(*payload.Name)
+	}
+
+	keyString, prefix, hash, err := utils.GenerateApiKey()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	//keys of inactive users stay inactive for the same reason as the user, so a key made before the first
+	//payment is turned on with the account
+	newKey := models.Key{
+		User:              user.ID,
+		Name:              name,
+		Prefix:            prefix,
+		Hash:              hash,
+		Routes:            routes,
+		AuthorizedDomains: authorizedDomains,
+		IsActive:          user.IsActive,
+		CreatedAt:         time.Now().Unix(),
+	}
+	if !user.IsActive {
+		newKey.DisabledReason = user.DisabledReason
+	}
+
+	result, err := keyCollection.InsertOne(ctx, newKey)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	newKey.ID = result.InsertedID.(primitive.ObjectID)
+
+	//keep the user's key list in sync, remove the key again if that fails
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$push": bson.M{"keys": newKey.ID}}); err != nil {
+		keyCollection.DeleteOne(ctx, bson.M{"_id": newKey.ID})
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	//the full key is only ever shown here, only its hash is stored
+	return c.Status(http.StatusCreated).JSON(utils.ApiResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"data": newKey, "key": keyString}})
+}
+
+func ListKeys(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user := c.Locals("user").(models.User)
+
+	cursor, err := keyCollection.Find(ctx, bson.M{"user": user.ID})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	keys := []models.Key{}
+	if err = cursor.All(ctx, &keys); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"keys": keys}})
+}
+
+// renames a key, changes its routes or authorized domains, or toggles is_active
+func UpdateKey(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user := c.Locals("user").(models.User)
+
+	keyID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid key id"}})
+	}
+
+	var payload keyPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	update := bson.M{}
+
+	if payload.Name != nil {
+		update["name"] = strings.TrimSpace(*payload.Name)
+	}
+
+	if payload.Routes != nil {
+		routes, err := cleanKeyRoutes(*payload.Routes)
+		if err != nil {
+			return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+		update["routes"] = routes
+	}
+
+	if payload.AuthorizedDomains != nil {
+		update["authorized_domains"] = cleanAuthorizedDomains(*payload.AuthorizedDomains)
+	}
+
+	if payload.IsActive != nil {
+		if *payload.IsActive && !user.IsActive {
+			return c.Status(http.StatusForbidden).JSON(utils.ApiResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": "Keys cannot be activated while your account is inactive"}})
+		}
+		update["is_active"] = *payload.IsActive
+	}
+
+	if len(update) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Nothing to update"}})
+	}
+
+	var key models.Key
+	err = keyCollection.FindOneAndUpdate(ctx, bson.M{"_id": keyID, "user": user.ID}, bson.M{"$set": update}, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&key)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusNotFound).JSON(utils.ApiResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Key not found"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": key}})
+}
+
+// permanently revokes a key and removes it from the user's key list
+func DeleteKey(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	user := c.Locals("user").(models.User)
+
+	keyID, err := primitive.ObjectIDFromHex(c.Params("id"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid key id"}})
+	}
+
+	result, err := keyCollection.DeleteOne(ctx, bson.M{"_id": keyID, "user": user.ID})
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if result.DeletedCount == 0 {
+		return c.Status(http.StatusNotFound).JSON(utils.ApiResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Key not found"}})
+	}
+
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$pull": bson.M{"keys": keyID}}); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Key revoked"}})
+}
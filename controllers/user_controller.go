@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"time"
 	"vehicle-api/configs"
@@ -16,6 +17,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,6 +25,19 @@ var userCollection *mongo.Collection = configs.GetCollection(configs.DB, "users"
 var keyCollection *mongo.Collection = configs.GetCollection(configs.DB, "keys")
 var validate = validator.New()
 
+// fields that are never sent back to the dashboard
+var privateUserFields = bson.M{"password": 0, "reset_token": 0, "reset_token_expiry": 0, "email_token": 0, "email_token_expiry": 0}
+
+// removes secrets before a user is returned in a response
+func publicUser(user models.User) models.User {
+	user.Password = ""
+	user.ResetToken = ""
+	user.ResetTokenExpiry = 0
+	user.EmailToken = ""
+	user.EmailTokenExpiry = 0
+	return user
+}
+
 func Register(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	var user models.User
@@ -40,7 +55,7 @@ func Register(c *fiber.Ctx) error {
 
 	//verify user doesn't already exist
 	var existingUser models.User
-	if err := userCollection.FindOne(ctx, models.User{Email: user.Email}).Decode(&existingUser); err == nil {
+	if err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&existingUser); err == nil {
 		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "User already exists"}})
 	}
 
@@ -64,7 +79,16 @@ func Register(c *fiber.Ctx) error {
 		Email: stripe.String(user.Email),
 		Name:  stripe.String(user.FirstName + " " + user.LastName),
 	}
-	customer, _ := customer.New(params)
+	customer, err := customer.New(params)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+	}
+
+	//token for the verification email
+	emailToken, err := utils.GenerateSecureToken()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
 
 	newUser := models.User{
 		FirstName:        user.FirstName,
@@ -74,7 +98,10 @@ func Register(c *fiber.Ctx) error {
 		Keys:             []primitive.ObjectID{},
 		CreatedAt:        time.Now().Unix(),
 		IsVerified:       false,
+		EmailToken:       emailToken,
+		EmailTokenExpiry: utils.EmailTokenExpiry(time.Now().Unix()),
 		IsActive:         false,
+		DisabledReason:   models.DisabledPayment,
 		StripeCustomerID: customer.ID,
 	}
 
@@ -83,62 +110,54 @@ func Register(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	//retrieve session from fiber
-	store, err := configs.GetSession().Get(c)
-	if err != nil {
+	newUser.ID = result.InsertedID.(primitive.ObjectID)
+
+	//log the new user in
+	if err := utils.StartUserSession(c, newUser.ID); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	//set the session values
-	store.Set("user", newUser)
-
-	//need to send user email to verify account
+	//send user email to verify account, the user can ask for it again if this fails
+	if err := utils.SendVerificationEmail(newUser); err != nil {
+		log.Println("Error sending verification email:", err)
+	}
 
-	return c.Status(http.StatusCreated).JSON(utils.ApiResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"data": result}})
+	return c.Status(http.StatusCreated).JSON(utils.ApiResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"data": publicUser(newUser)}})
 }
 
 // users should only have one setup intent at a time, so we will use the old one if it exists
 func CreateSetupIntent(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	//retrieve session from fiber
-	store, err := configs.GetSession().Get(c)
-	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-	}
 
-	//retrieve user from session
-	user := store.Get("user")
-
-	//verify that user doesn't already have a setup intent created
-	var existingUser models.User
-	if err := userCollection.FindOne(ctx, bson.M{"_id": user.(models.User).ID}).Decode(&existingUser); err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-	}
+	//user is loaded by the user middleware
+	user := c.Locals("user").(models.User)
 
-	stripe.Key = "sk_test_51NJLD1Ehn7EiUqOg07gWZiJHwYjTNzyPPTWxjGPHcVY9ub97ZjUQwPokfCphjwZRzrmNwywulQUyu3SbMJ2hxNZV00EMTaqsyP"
+	stripe.Key = configs.RetrieveEnv("STRIPE_SECRET_KEY")
 
-	//if user already has a setup intent, return the client secret
-	if existingUser.SetupIntentID != "" {
-		si, err := setupintent.Get(
-			"seti_1NTW0L2eZvKYlo2CWChztztT",
-			nil,
-		)
-		if err == nil {
+	//if user already has a setup intent that can still be used, return the client secret
+	if user.SetupIntentID != "" {
+		si, err := setupintent.Get(user.SetupIntentID, nil)
+		if err == nil && si.Status == stripe.SetupIntentStatusRequiresPaymentMethod {
 			return c.Status(http.StatusCreated).JSON(utils.ApiResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"client_secret": si.ClientSecret}})
 		}
 	}
 
 	params := &stripe.SetupIntentParams{
-		Customer: stripe.String(user.(models.User).StripeCustomerID),
+		Customer: stripe.String(user.StripeCustomerID),
 		PaymentMethodTypes: []*string{
 			stripe.String("card"),
 		},
 	}
-	si, _ := setupintent.New(params)
+	si, err := setupintent.New(params)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+	}
 
 	//update the user in the database with the setup intent id
-	_, err = userCollection.UpdateOne(ctx, bson.M{"_id": user.(models.User).ID}, bson.M{"$set": bson.M{"setup_intent_id": si.ID}})
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"_id": user.ID}, bson.M{"$set": bson.M{"setup_intent_id": si.ID}}); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
 
 	return c.Status(http.StatusCreated).JSON(utils.ApiResponse{Status: http.StatusCreated, Message: "success", Data: &fiber.Map{"client_secret": si.ClientSecret}})
 }
@@ -158,27 +177,22 @@ func Login(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Email and password are required"}})
 	}
 
-	//verify user exists
+	//verify user exists and password is correct, don't reveal which one failed
 	var existingUser models.User
-	if err := userCollection.FindOne(ctx, models.User{Email: user.Email}).Decode(&existingUser); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "User does not exist"}})
+	if err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&existingUser); err != nil {
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Email and password do not match"}})
 	}
 
-	//verify password is correct
 	if err := bcrypt.CompareHashAndPassword([]byte(existingUser.Password), []byte(user.Password)); err != nil {
-		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Email and password do not match"}})
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Email and password do not match"}})
 	}
 
-	//retrieve session from fiber
-	store, err := configs.GetSession().Get(c)
-	if err != nil {
+	//set the session values
+	if err := utils.StartUserSession(c, existingUser.ID); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	//set the session values
-	store.Set("user", existingUser)
-
-	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": existingUser}})
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": publicUser(existingUser)}})
 }
 
 func Logout(c *fiber.Ctx) error {
@@ -188,10 +202,14 @@ func Logout(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	//delete the session
+	//remove it from the user's session list, then delete the session
+	sessionUser, _ := store.Get("user").(string)
+	if userID, err := primitive.ObjectIDFromHex(sessionUser); err == nil {
+		utils.ForgetUserSession(c.Context(), userID, store.ID())
+	}
 	store.Destroy()
 
-	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"signed_url": "hello"}})
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Logged out"}})
 }
 
 func ForgotPassword(c *fiber.Ctx) error {
@@ -210,20 +228,29 @@ func ForgotPassword(c *fiber.Ctx) error {
 
 	//verify user exists
 	var existingUser models.User
-	if err := userCollection.FindOne(ctx, models.User{Email: user.Email}).Decode(&existingUser); err != nil {
+	if err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&existingUser); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "User does not exist"}})
 	}
 
 	//generate a random string
-	randomString := utils.GenerateRandomString(32)
+	randomString, err := utils.GenerateSecureToken()
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
 
 	//update the user with the random string and expiry a day from now
 	dayFromNow := time.Now().AddDate(0, 0, 1).Unix()
-	if err := userCollection.FindOneAndUpdate(ctx, models.User{Email: user.Email}, bson.M{"$set": bson.M{"reset_token": randomString, "reset_token_expiry": dayFromNow}}).Decode(&existingUser); err != nil {
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"_id": existingUser.ID}, bson.M{"$set": bson.M{"reset_token": randomString, "reset_token_expiry": dayFromNow}}); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"signed_url": "hello"}})
+	//send the reset link
+	existingUser.ResetToken = randomString
+	if err := utils.SendPasswordResetEmail(existingUser); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Could not send the password reset email. Please try again later."}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Password reset email sent"}})
 }
 
 func ResetPassword(c *fiber.Ctx) error {
@@ -243,15 +270,20 @@ func ResetPassword(c *fiber.Ctx) error {
 
 	//verify user exists
 	var existingUser models.User
-	if err := userCollection.FindOne(ctx, models.User{Email: user.Email}).Decode(&existingUser); err != nil {
+	if err := userCollection.FindOne(ctx, bson.M{"email": user.Email}).Decode(&existingUser); err != nil {
 		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "User does not exist"}})
 	}
 
 	//verify reset token is valid
-	if existingUser.ResetToken != user.ResetToken || existingUser.ResetTokenExpiry < time.Now().Unix() {
+	if existingUser.ResetToken == "" || existingUser.ResetToken != user.ResetToken || existingUser.ResetTokenExpiry < time.Now().Unix() {
 		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Reset token is invalid"}})
 	}
 
+	//validate new password
+	if !utils.ValidatePassword(user.Password) {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Password must be at least 8 characters, have a number, a capital letter, and a special character"}})
+	}
+
 	//hash the password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 10)
 	if err != nil {
@@ -259,10 +291,15 @@ func ResetPassword(c *fiber.Ctx) error {
 	}
 
 	//update the user with the new password and remove the reset token
-	if err := userCollection.FindOneAndUpdate(ctx, models.User{Email: user.Email}, bson.M{"$set": bson.M{"password": string(hashedPassword), "reset_token": nil, "reset_token_expiry": nil}}).Decode(&existingUser); err != nil {
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"_id": existingUser.ID}, bson.M{"$set": bson.M{"password": string(hashedPassword)}, "$unset": bson.M{"reset_token": "", "reset_token_expiry": ""}}); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
+	//whoever had the old password shouldn't stay logged in
+	if err := utils.RevokeUserSessions(ctx, existingUser.ID, ""); err != nil {
+		log.Println("Error revoking sessions after password reset:", err)
+	}
+
 	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Password reset successful"}})
 }
 
@@ -275,22 +312,8 @@ func ChangePassword(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	//retrieve session from fiber
-	store, err := configs.GetSession().Get(c)
-	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-	}
-
-	//retrieve user from session
-	user := store.Get("user")
-	if user == nil {
-		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Unauthorized"}})
-	}
-
-	var existingUser models.User
-	if err := userCollection.FindOne(ctx, models.User{Email: user.(models.User).Email}).Decode(&existingUser); err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-	}
+	//user is loaded by the user middleware
+	existingUser := c.Locals("user").(models.User)
 
 	//validate the request body
 	if err := c.BodyParser(&payload); err != nil {
@@ -305,7 +328,7 @@ func ChangePassword(c *fiber.Ctx) error {
 	//validate new password
 	passwordValid := utils.ValidatePassword(payload.NewPassword)
 	if !passwordValid {
-		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Password must be at least 8 characters and contain at least one uppercase letter, one lowercase letter, one number and one special character"}})
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Password must be at least 8 characters, have a number, a capital letter, and a special character"}})
 	}
 
 	//hash the password
@@ -315,10 +338,15 @@ func ChangePassword(c *fiber.Ctx) error {
 	}
 
 	//update the user with the new password
-	if err := userCollection.FindOneAndUpdate(ctx, models.User{Email: user.(models.User).Email}, bson.M{"$set": bson.M{"password": string(hashedPassword)}}).Decode(&existingUser); err != nil {
+	if _, err := userCollection.UpdateOne(ctx, bson.M{"_id": existingUser.ID}, bson.M{"$set": bson.M{"password": string(hashedPassword)}}); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
+	//log out every other session, the current one stays logged in
+	if err := utils.RevokeUserSessions(ctx, existingUser.ID, c.Locals("session_id").(string)); err != nil {
+		log.Println("Error revoking sessions after password change:", err)
+	}
+
 	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Password changed successfully"}})
 }
 
@@ -326,17 +354,8 @@ func UpdateProfile(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	//retrieve session from fiber
-	store, err := configs.GetSession().Get(c)
-	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-	}
-
-	//retrieve user from session
-	user := store.Get("user")
-	if user == nil {
-		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Unauthorized"}})
-	}
+	//user is loaded by the user middleware
+	user := c.Locals("user").(models.User)
 
 	//validate the request body
 	var payload models.User
@@ -344,63 +363,129 @@ func UpdateProfile(c *fiber.Ctx) error {
 		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	if err := userCollection.FindOneAndUpdate(ctx, models.User{Email: user.(models.User).Email}, bson.M{"$set": bson.M{"first_name": payload.FirstName, "last_name": payload.LastName}}).Decode(&user); err != nil {
+	//only the name can be changed here, empty fields are left as they are
+	update := bson.M{}
+	if payload.FirstName != "" {
+		update["first_name"] = payload.FirstName
+	}
+	if payload.LastName != "" {
+		update["last_name"] = payload.LastName
+	}
+
+	if len(update) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Nothing to update"}})
+	}
+
+	var updatedUser models.User
+	opts := options.FindOneAndUpdate().SetReturnDocument(options.After).SetProjection(privateUserFields)
+	if err := userCollection.FindOneAndUpdate(ctx, bson.M{"_id": user.ID}, bson.M{"$set": update}, opts).Decode(&updatedUser); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
-	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"signed_url": "hello"}})
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": updatedUser}})
 }
 
 func GetProfile(c *fiber.Ctx) error {
+	//user is loaded fresh from the database by the user middleware
+	user := c.Locals("user").(models.User)
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": publicUser(user)}})
+}
+
+func DeleteAccount(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	//retrieve session from fiber
-	store, err := configs.GetSession().Get(c)
-	if err != nil {
+	//user is loaded by the user middleware
+	user := c.Locals("user").(models.User)
+
+	//delete all keys associated with the user first so none are left working without an owner
+	if _, err := keyCollection.DeleteMany(ctx, bson.M{"user": user.ID}); err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	//retrieve user from session
-	user := store.Get("user")
-	if user == nil {
-		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Unauthorized"}})
+	//delete the user
+	if _, err := userCollection.DeleteOne(ctx, bson.M{"_id": user.ID}); err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	var existingUser models.User
-	if err := userCollection.FindOne(ctx, models.User{Email: user.(models.User).Email}).Decode(&existingUser); err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	//log out everywhere
+	if err := utils.RevokeUserSessions(ctx, user.ID, ""); err != nil {
+		log.Println("Error revoking sessions after account deletion:", err)
 	}
-	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": existingUser}})
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Account deleted successfully"}})
 }
 
-func DeleteAccount(c *fiber.Ctx) error {
+// returns the csrf token the dashboard has to send back in the X-Csrf-Token header, it is also set as a cookie
+func GetCsrfToken(c *fiber.Ctx) error {
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"csrf_token": c.Locals("csrf")}})
+}
+
+func VerifyEmail(c *fiber.Ctx) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	//retrieve session from fiber
-	store, err := configs.GetSession().Get(c)
+	payload := struct {
+		Token string `json:"token"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if payload.Token == "" {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Token is required"}})
+	}
+
+	//verify the token exists and hasn't expired, then clear it so it can't be used again
+	filter := bson.M{"email_token": payload.Token, "email_token_expiry": bson.M{"$gte": time.Now().Unix()}}
+	update := bson.M{"$set": bson.M{"is_verified": true}, "$unset": bson.M{"email_token": "", "email_token_expiry": ""}}
+
+	result, err := userCollection.UpdateOne(ctx, filter, update)
 	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	//retrieve user from session
-	user := store.Get("user")
-	if user == nil {
-		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Unauthorized"}})
+	if result.MatchedCount == 0 {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Verification token is invalid or has expired"}})
 	}
 
-	//delete the user
-	if _, err := userCollection.DeleteOne(ctx, models.User{Email: user.(models.User).Email}); err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Email verified"}})
+}
+
+// sends a new verification email. always succeeds so it can't be used to find out which emails have accounts
+func ResendVerificationEmail(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	payload := struct {
+		Email string `json:"email"`
+	}{}
+
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	//delete all keys associated with the user
-	if _, err := keyCollection.DeleteMany(ctx, bson.M{"user": user.(models.User).ID}); err != nil {
+	if payload.Email == "" {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Email is required"}})
+	}
+
+	emailToken, err := utils.GenerateSecureToken()
+	if err != nil {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	//delete session
-	store.Destroy()
+	var user models.User
+	update := bson.M{"$set": bson.M{"email_token": emailToken, "email_token_expiry": utils.EmailTokenExpiry(time.Now().Unix())}}
+	err = userCollection.FindOneAndUpdate(ctx, bson.M{"email": payload.Email, "is_verified": false}, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&user)
 
-	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "Account deleted successfully"}})
+	if err == nil {
+		if err := utils.SendVerificationEmail(user); err != nil {
+			log.Println("Error sending verification email:", err)
+		}
+	} else if err != mongo.ErrNoDocuments {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": "If the account exists and isn't verified yet, a verification email has been sent"}})
 }
@@ -0,0 +1,138 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// values up to utils.MaxBatchRows vehicles in one call. rows are sent as a json array, a csv body or a csv
+// file uploaded as "file", and results come back as json or, with format=csv or Accept: text/csv, as csv.
+// every row that is valued is billed like a single valuation, rows that fail cost nothing
+func ValuationBatchController(c *fiber.Ctx) error {
+	requests, err := parseBatchRows(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if len(requests) == 0 {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "At least one row is required"}})
+	}
+
+	if len(requests) > utils.MaxBatchRows {
+		return c.Status(http.StatusRequestEntityTooLarge).JSON(utils.ApiResponse{Status: http.StatusRequestEntityTooLarge, Message: "error", Data: &fiber.Map{"data": "A batch can have at most " + strconv.Itoa(utils.MaxBatchRows) + " rows"}})
+	}
+
+	control, err := parseCacheControl(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	key, _ := c.Locals("key").(*models.Key)
+
+	//only rows that pass validation are reserved, the whole reservation has to fit in the monthly quota
+	//before any of it is run
+	valid := 0
+	for _, request := range requests {
+		if request.Validate() == nil {
+			valid++
+		}
+	}
+
+	var quota utils.RateLimitResult
+	if key != nil && valid > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		quota, err = utils.CheckQuota(ctx, *key, "valuation", valid)
+		cancel()
+
+		if err == nil && !quota.Allowed {
+			c.Set("Retry-After", strconv.FormatInt(quota.RetryAfter, 10))
+			return c.Status(http.StatusTooManyRequests).JSON(utils.ApiResponse{Status: http.StatusTooManyRequests, Message: "error", Data: &fiber.Map{"data": "Monthly quota exceeded, " + strconv.Itoa(quota.QuotaRemaining) + " valuations remaining"}})
+		}
+		if err != nil {
+			log.Println("Error reserving batch quota:", err)
+		}
+	}
+
+	start := time.Now()
+	results := utils.RunValuationBatch(context.Background(), requests, control)
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Status == http.StatusOK {
+			succeeded++
+		}
+	}
+
+	//only rows that were valued are billed, give the rest of the reservation back
+	if key != nil && err == nil && valid > succeeded {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		if err := utils.RefundQuota(ctx, *key, "valuation", quota, valid-succeeded); err != nil {
+			log.Println("Error refunding batch quota:", err)
+		}
+		cancel()
+	}
+
+	//fiber reuses the request buffer once the handler returns, copy the url before handing it off
+	go utils.LogBatchCalls(key, strings.Clone(c.OriginalURL()), results, time.Since(start))
+
+	if c.Query("format") == "csv" || (c.Query("format") == "" && strings.Contains(c.Get(fiber.HeaderAccept), "text/csv")) {
+		var buffer bytes.Buffer
+		if err := utils.WriteValuationCSV(&buffer, results); err != nil {
+			return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+		}
+
+		c.Set(fiber.HeaderContentType, "text/csv")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="valuations.csv"`)
+		return c.Status(http.StatusOK).Send(buffer.Bytes())
+	}
+
+	rows := make([]fiber.Map, len(results))
+	for i, result := range results {
+		rows[i] = result.Response()
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{
+		"rows":      rows,
+		"succeeded": succeeded,
+		"failed":    len(results) - succeeded,
+	}})
+}
+
+// reads the rows of a batch from the body
+func parseBatchRows(c *fiber.Ctx) ([]utils.ValuationRequest, error) {
+	contentType := strings.ToLower(c.Get(fiber.HeaderContentType))
+
+	switch {
+	case strings.HasPrefix(contentType, fiber.MIMEMultipartForm):
+		header, err := c.FormFile("file")
+		if err != nil {
+			return nil, fmt.Errorf("Upload the CSV as a file named file")
+		}
+
+		file, err := header.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		return utils.ParseValuationCSV(file)
+	case strings.HasPrefix(contentType, "text/csv"):
+		return utils.ParseValuationCSV(bytes.NewReader(c.Body()))
+	}
+
+	var requests []utils.ValuationRequest
+	if err := c.BodyParser(&requests); err != nil {
+		return nil, fmt.Errorf("Rows must be a JSON array or a CSV")
+	}
+	return requests, nil
+}
@@ -0,0 +1,90 @@
+package controllers
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"vehicle-api/utils"
+	"vehicle-api/valuation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// lists the listings behind a valuation with the same query params as the valuation itself. sorted by
+// sort (price, mileage, year or distance) and order (asc or desc), paginated with page and limit and
+// optionally filtered to excluded=true or excluded=false
+func ValuationComparablesController(c *fiber.Ctx) error {
+	request, err := parseValuationQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	sortField := c.Query("sort", "price")
+	order := c.Query("order", "asc")
+	if order != "asc" && order != "desc" {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "order must be asc or desc"}})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), utils.SyncValuationTimeout)
+	defer cancel()
+
+	set, err := utils.FindComparables(ctx, request)
+	if err != nil {
+		return valuationError(c, err)
+	}
+
+	all := set.All()
+	used := 0
+	for _, comparable := range all {
+		if !comparable.Excluded {
+			used++
+		}
+	}
+
+	comparables := []valuation.Comparable{}
+	for _, comparable := range all {
+		switch c.Query("excluded") {
+		case "true":
+			if !comparable.Excluded {
+				continue
+			}
+		case "false":
+			if comparable.Excluded {
+				continue
+			}
+		}
+		comparables = append(comparables, comparable)
+	}
+
+	if err := valuation.SortComparables(comparables, sortField, order == "desc"); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	page, limit := utils.GetPagination(c)
+	total := int64(len(comparables))
+
+	start := (page - 1) * limit
+	if start > total {
+		start = total
+	}
+	end := start + limit
+	if end > total {
+		end = total
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{
+		"comparables":         comparables[start:end],
+		"page":                page,
+		"limit":               limit,
+		"total":               total,
+		"used":                used,
+		"excluded":            len(all) - used,
+		"year":                set.Spec.Year,
+		"make":                set.Spec.Make,
+		"model":               set.Spec.Model,
+		"trim":                set.Trim,
+		"trim_matched":        set.TrimMatched,
+		"cached":              set.Cached,
+		"listings_fetched_at": set.FetchedAt.UTC().Format(time.RFC3339),
+	}})
+}
@@ -1,20 +1,15 @@
 package controllers
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
-	"math"
 	"net/http"
-	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"vehicle-api/utils"
 
-	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
-
-	"github.com/sajari/regression"
-	"golang.org/x/net/html"
 )
 
 //redis keys
@@ -26,305 +21,85 @@ import (
 */
 
 func ValuationController(c *fiber.Ctx) error {
-
-	var vin = c.Query("vin")
-	var zipCode = c.Query("zip_code")
-	var radius = c.Query("radius")
-	var mileage = c.Query("mileage")
-	var multipleYears = c.Query("multiple_years")
-
-	responseVin, err := http.Get("https://vpic.nhtsa.dot.gov/api/vehicles/decodevin/" + vin + "?format=json")
+	request, err := parseValuationQuery(c)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	defer responseVin.Body.Close()
+	//synchronous valuations have to finish within the timeout, slower ones should use a job
+	ctx, cancel := context.WithTimeout(context.Background(), utils.SyncValuationTimeout)
+	defer cancel()
 
-	// Read the response body
-	responseBody, err := ioutil.ReadAll(responseVin.Body)
+	result, err := utils.RunValuation(ctx, request)
 	if err != nil {
-		fmt.Println("Error reading response body:", err)
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		return valuationError(c, err)
 	}
 
-	// Create a struct to unmarshal the JSON response
-	var decodedVin struct {
-		Results []struct {
-			Variable   string `json:"Variable"`
-			Value      string `json:"Value"`
-			VariableId int    `json:"VariableId"`
-			ValueId    string `json:"ValueId"`
-		} `json:"Results"`
-	}
+	response := result.Response()
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &response})
+}
 
-	// Unmarshal the JSON response into the struct
-	if err := json.Unmarshal(responseBody, &decodedVin); err != nil {
-		fmt.Println("Error unmarshaling JSON:", err)
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+// reads a valuation request from the query string
+func parseValuationQuery(c *fiber.Ctx) (utils.ValuationRequest, error) {
+	request := utils.ValuationRequest{
+		VIN:              c.Query("vin"),
+		ZipCode:          c.Query("zip_code"),
+		MultipleYears:    c.Query("multiple_years") == "true",
+		Model:            c.Query("model"),
+		Outliers:         c.Query("outliers"),
+		IncludeCertified: c.Query("include_certified") == "true",
+		Trim:             c.Query("trim"),
+		Condition:        c.Query("condition"),
 	}
 
-	// Check if there are results
-	if len(decodedVin.Results) <= 0 {
-		fmt.Println("No results found for the VIN.")
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "No results found for the VIN."}})
+	//options are comma separated, e.g. options=sunroof,leather
+	if options := c.Query("options"); options != "" {
+		request.Options = strings.Split(options, ",")
 	}
 
-	var year string
-	var make string
-	var model string
-
-	for _, result := range decodedVin.Results {
-		if result.Variable == "Model Year" {
-			year = result.Value
-		}
-		if result.Variable == "Make" {
-			make = result.Value
+	var err error
+	if radius := c.Query("radius"); radius != "" {
+		if request.Radius, err = strconv.Atoi(radius); err != nil {
+			return request, fmt.Errorf("Radius must be a number")
 		}
-		if result.Variable == "Model" {
-			model = result.Value
-		}
-		/*if result.Variable == "Series" {
-			series = result.Value
-		}*/
-	}
-
-	if year == "" || make == "" || model == "" {
-		fmt.Println("No results found for the VIN.")
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "No results found for the VIN."}})
 	}
 
-	//get valuation
-	var url string
-	var multipleYearsString string = year + "/"
-	if multipleYears == "true" {
-		multipleYearsString = ""
-	}
-
-	if radius == "" {
-		url = "https://www.autotrader.com/cars-for-sale/all-cars/" + multipleYearsString + strings.ReplaceAll(strings.ToLower(make), " ", "-") + "/" + strings.ReplaceAll(strings.ToLower(model), " ", "-") + "?numRecords=100&searchRadius=100&zip=" + zipCode
-	} else {
-		url = "https://www.autotrader.com/cars-for-sale/all-cars/" + multipleYearsString + strings.ReplaceAll(strings.ToLower(make), " ", "-") + "/" + strings.ReplaceAll(strings.ToLower(model), " ", "-") + "?numRecords=100&searchRadius=" + radius + "&zip=" + zipCode
+	if mileage := c.Query("mileage"); mileage != "" {
+		if request.Mileage, err = strconv.Atoi(mileage); err != nil {
+			return request, fmt.Errorf("Mileage must be a number")
+		}
 	}
 
-	fmt.Println(url)
-	client := &http.Client{}
-	req, _ := http.NewRequest("GET", url, nil)
-
-	response, err := client.Do(req)
+	control, err := parseCacheControl(c)
 	if err != nil {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+		return request, err
 	}
+	request.NoCache = control.NoCache
+	request.MaxAge = int(control.MaxAge / time.Second)
 
-	defer response.Body.Close()
-
-	//tokenize the response html
-	z := html.NewTokenizer(response.Body)
-
-	isProductElement := false
-	isPriceElement := false
-	isMileageElement := false
-	isListingTitleElement := false
-	prices := []int{}
-	mileages := []int{}
-	listingTitles := []string{}
-
-	//loop through the tokens
-	for {
-		tt := z.Next()
-
-		switch {
-		case tt == html.ErrorToken:
-			// End of the document, we're done
-
-			//remove all listings that are new vehicles
-			years := []int{}
-
-			for i, listingTitle := range listingTitles {
-				if strings.Contains(listingTitle, "New") {
-					prices = append(prices[:i], prices[i+1:]...)
-					mileages = append(mileages[:i], mileages[i+1:]...)
-				} else {
-					// Define a regular expression pattern to match four consecutive numbers
-					pattern := `[0-9]{4}`
-
-					// Compile the regular expression
-					regexpPattern := regexp.MustCompile(pattern)
-
-					// Find all matches in the input string
-					matches := regexpPattern.FindAllString(listingTitle, -1)
-
-					if len(matches) == 0 {
-						return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
-					}
-
-					yearAsInt, err := strconv.Atoi(matches[0])
-					if err != nil {
-						fmt.Println("Error converting year to int")
-						return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-					}
-
-					years = append(years, yearAsInt)
-				}
-			}
-
-			//check that all maps are the same length
-			if len(prices) != len(mileages) || len(prices) != len(years) {
-				fmt.Println("Error: prices, mileages, and years are not the same length")
-				fmt.Println(strconv.Itoa(len(prices))+" prices: ", prices)
-				fmt.Println(strconv.Itoa(len(mileages))+" mileages: ", mileages)
-				fmt.Println(strconv.Itoa(len(years))+" years: ", years)
-				return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
-			}
-
-			if len(prices) < 2 {
-				fmt.Println("Error: not enough results.")
-				return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Not enough results. Please expand the search radius and try querying with multipleYears=true"}})
-			}
-
-			r := new(regression.Regression)
-			r.SetObserved("Price")
-			r.SetVar(0, "Mileage")
-			r.SetVar(1, "Year")
-
-			for i, price := range prices {
-				r.Train(regression.DataPoint(float64(price), []float64{float64(mileages[i]), float64(years[i])}))
-			}
-			r.Run()
-
-			fmt.Printf("Regression formula:\n%v\n", r.Formula)
-			fmt.Printf("Regression:\n%s\n", r)
-
-			//return valuation
-			yearInt, err := strconv.Atoi(year)
-			if err != nil {
-				fmt.Println("Error converting year to int")
-				return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-			}
-
-			var mileageInt int
-
-			if mileage == "" {
-				//get average mileage for specific year
-				var totalMileage int
-				var totalRecords int
-
-				for i, year := range years {
-					if year == yearInt {
-						totalMileage += mileages[i]
-						totalRecords++
-					}
-				}
-
-				if totalRecords == 0 {
-					//get average mileage for all years
-					for _, mileage := range mileages {
-						totalMileage += mileage
-					}
-					totalRecords = len(mileages)
-				}
-
-				mileageInt = totalMileage / totalRecords
-
-			} else {
-				mileageInt, err = strconv.Atoi(mileage)
-				if err != nil {
-					fmt.Println("Error converting mileage to int")
-					return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-				}
-			}
-
-			prediction, err := r.Predict([]float64{float64(mileageInt), float64(yearInt)})
-
-			if err != nil {
-				fmt.Println("Error predicting price")
-			}
-
-			return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{
-				"predicted_price": math.Floor(prediction*100) / 100,
-				"based_on":        strconv.Itoa(len(prices)) + " results",
-				"mileage":         strconv.Itoa(mileageInt),
-				"year":            year,
-				"make":            make,
-				"model":           model,
-			}})
-
-		case tt == html.StartTagToken:
-			t := z.Token()
-
-			if t.Type == html.StartTagToken && t.Data == "div" {
-				for _, a := range t.Attr {
-					if a.Key == "class" && strings.Contains(a.Val, "item-card") {
-						isProductElement = true
-					}
-				}
-			}
-
-			if t.Type == html.StartTagToken && t.Data == "span" {
-				for _, a := range t.Attr {
-					if a.Key == "class" && strings.Contains(a.Val, "first-price") {
-						isPriceElement = true
-					}
-					if a.Key == "class" && strings.Contains(a.Val, "text-bold") {
-						isMileageElement = true
-					}
-				}
-			}
-
-			if t.Type == html.StartTagToken && t.Data == "h3" {
-				for _, a := range t.Attr {
-					if a.Key == "class" && strings.Contains(a.Val, "text-bold") {
-						isListingTitleElement = true
-					}
-				}
-			}
-		case tt == html.TextToken:
-			t := z.Token()
-
-			if isProductElement {
-				if isMileageElement && strings.Contains(t.Data, " miles") && len(t.Data) < 15 && len(t.Data) > 0 {
-					mileage, err := strconv.Atoi(strings.ReplaceAll(strings.ReplaceAll(t.Data, ",", ""), " miles", ""))
-					if err != nil {
-						fmt.Println("Error converting mileage to int")
-						return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-					}
-					mileages = append(mileages, mileage)
-					isMileageElement = false
-				}
-
-				if isPriceElement {
-					//check that current element has both mileage and title
-					if len(mileages) < len(listingTitles) {
-						//remove previous listing title
-						listingTitles = listingTitles[:len(listingTitles)-1]
-						isProductElement = false
-					}
+	return request, request.Validate()
+}
 
-					//check that it's not dealer price - if it is, there will be an extra two titles and mileages
-					//need to remove the second to last title and mileage
-					if len(listingTitles) > len(prices)+1 && len(mileages) == len(listingTitles) {
-						indexToRemove := len(listingTitles) - 2
+// Cache-Control: no-cache or max-age=N decide how fresh the listings must be, the max_age query param overrides the header
+func parseCacheControl(c *fiber.Ctx) (utils.CacheControl, error) {
+	control := utils.ParseCacheControl(c.Get(fiber.HeaderCacheControl))
 
-						// Remove the second-to-last element by slicing the slice
-						listingTitles = append(listingTitles[:indexToRemove], listingTitles[indexToRemove+1:]...)
-						mileages = append(mileages[:indexToRemove], mileages[indexToRemove+1:]...)
-					}
+	if maxAge := c.Query("max_age"); maxAge != "" {
+		seconds, err := strconv.Atoi(maxAge)
+		if err != nil || seconds < 0 {
+			return control, fmt.Errorf("max_age must be a number of seconds")
+		}
+		control = utils.CacheControl{NoCache: seconds == 0, MaxAge: time.Duration(seconds) * time.Second}
+	}
 
-					price, err := strconv.Atoi(strings.ReplaceAll(t.Data, ",", ""))
-					if err != nil {
-						fmt.Println("Error converting price to int")
-						return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
-					}
-					prices = append(prices, price)
-					isProductElement = false
-					isPriceElement = false
-				}
+	return control, nil
+}
 
-				if isListingTitleElement && len(t.Data) > 0 && len(t.Data) < 250 {
-					listingTitle := t.Data
-					listingTitles = append(listingTitles, listingTitle)
-					isListingTitleElement = false
-				}
-			}
-		}
+// maps a valuation error to a response
+func valuationError(c *fiber.Ctx, err error) error {
+	status, message := utils.ValuationErrorStatus(err)
+	if status == http.StatusInternalServerError {
+		fmt.Println("Error valuing vehicle:", err)
 	}
+	return c.Status(status).JSON(utils.ApiResponse{Status: status, Message: "error", Data: &fiber.Map{"data": message}})
 }
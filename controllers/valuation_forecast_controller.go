@@ -0,0 +1,55 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// forecasts residual values for the next years (years, default 3) at annual_mileage miles a year (default
+// 12,000) and values the car today at a range of mileages. takes the same query params as a valuation
+func ValuationForecastController(c *fiber.Ctx) error {
+	request, err := parseForecastQuery(c)
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), utils.SyncValuationTimeout)
+	defer cancel()
+
+	result, err := utils.RunForecast(ctx, request)
+	if err != nil {
+		return valuationError(c, err)
+	}
+
+	response := result.Response()
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &response})
+}
+
+func parseForecastQuery(c *fiber.Ctx) (utils.ForecastRequest, error) {
+	var request utils.ForecastRequest
+
+	valuationRequest, err := parseValuationQuery(c)
+	if err != nil {
+		return request, err
+	}
+	request.ValuationRequest = valuationRequest
+
+	if annualMileage := c.Query("annual_mileage"); annualMileage != "" {
+		if request.AnnualMileage, err = strconv.Atoi(annualMileage); err != nil {
+			return request, fmt.Errorf("annual_mileage must be a number")
+		}
+	}
+
+	if years := c.Query("years"); years != "" {
+		if request.Years, err = strconv.Atoi(years); err != nil {
+			return request, fmt.Errorf("years must be a number")
+		}
+	}
+
+	return request, request.Validate()
+}
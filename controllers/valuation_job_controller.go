@@ -0,0 +1,78 @@
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+type valuationJobPayload struct {
+	utils.ValuationRequest
+	CallbackURL string `json:"callback_url"`
+}
+
+// queues a valuation and returns the job id to poll, the webhook signing secret is only returned here
+func CreateValuationJob(c *fiber.Ctx) error {
+	var payload valuationJobPayload
+	if err := c.BodyParser(&payload); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid request body"}})
+	}
+
+	if err := payload.ValuationRequest.Validate(); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if payload.CallbackURL != "" {
+		if err := utils.ValidateCallbackURL(payload.CallbackURL); err != nil {
+			return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, _ := c.Locals("key").(*models.Key)
+
+	job, err := utils.CreateValuationJob(ctx, payload.ValuationRequest, payload.CallbackURL, key)
+	if err != nil {
+		fmt.Println("Error creating valuation job:", err)
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+	}
+
+	response := job.Response()
+	if job.SigningSecret != "" {
+		response["signing_secret"] = job.SigningSecret
+	}
+
+	c.Set(fiber.HeaderLocation, "/api/v1/valuation/jobs/"+job.ID)
+	return c.Status(http.StatusAccepted).JSON(utils.ApiResponse{Status: http.StatusAccepted, Message: "success", Data: &response})
+}
+
+func GetValuationJob(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	job, err := utils.GetValuationJob(ctx, c.Params("id"))
+	if err != nil && err != utils.ErrJobNotFound {
+		fmt.Println("Error finding valuation job:", err)
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+	}
+
+	//jobs of other customers look the same as ones that don't exist
+	userID := ""
+	if key, ok := c.Locals("key").(*models.Key); ok {
+		userID = key.User.Hex()
+	}
+
+	if err == utils.ErrJobNotFound || !job.OwnedBy(userID) {
+		return c.Status(http.StatusNotFound).JSON(utils.ApiResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "Job not found"}})
+	}
+
+	response := job.Response()
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &response})
+}
@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"vehicle-api/utils"
+	"vehicle-api/vin"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// decodes a VIN into its vehicle specs
+func DecodeVin(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	info, err := vin.Parse(c.Query("vin"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	spec, err := utils.DecodeVin(ctx, info.VIN)
+	if err != nil {
+		if err == utils.ErrVinNotFound {
+			return c.Status(http.StatusNotFound).JSON(utils.ApiResponse{Status: http.StatusNotFound, Message: "error", Data: &fiber.Map{"data": "No results found for the VIN."}})
+		}
+		log.Println("Error decoding VIN:", err)
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+	}
+
+	//vPIC doesn't always know the manufacturer of imports
+	if spec.Manufacturer == "" {
+		spec.Manufacturer = info.Manufacturer
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"data": spec}})
+}
@@ -0,0 +1,36 @@
+package controllers
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+	"vehicle-api/billing"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func StripeWebhook(c *fiber.Ctx) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+
+	event, err := billing.ConstructEvent(c.Body(), c.Get("Stripe-Signature"))
+	if err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Invalid signature"}})
+	}
+
+	if err := billing.HandleEvent(ctx, event); err != nil {
+		//events for customers we don't know about can't be retried into success
+		if err == mongo.ErrNoDocuments {
+			log.Println("No user found for stripe event " + event.ID)
+			return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"received": true}})
+		}
+
+		log.Println("Error handling stripe event "+event.ID+":", err)
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+	}
+
+	return c.Status(http.StatusOK).JSON(utils.ApiResponse{Status: http.StatusOK, Message: "success", Data: &fiber.Map{"received": true}})
+}
@@ -8,7 +8,6 @@ require (
 	github.com/gofiber/fiber/v2 v2.47.0
 	github.com/joho/godotenv v1.5.1
 	github.com/redis/go-redis/v9 v9.0.5
-	github.com/sajari/regression v1.0.1
 	github.com/stripe/stripe-go/v74 v74.25.0
 	go.mongodb.org/mongo-driver v1.12.0
 	golang.org/x/crypto v0.13.0
@@ -46,5 +45,4 @@ require (
 	golang.org/x/sync v0.3.0 // indirect
 	golang.org/x/sys v0.12.0 // indirect
 	golang.org/x/text v0.13.0 // indirect
-	gonum.org/v1/gonum v0.14.0 // indirect
 )
@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+var ErrNotFound = errors.New("valuation job not found")
+
+// what requeueing needs from the job queue
+type Store interface {
+	//ids of the jobs workers have taken
+	Processing(ctx context.Context) ([]string, error)
+	//when a worker took the job (unix seconds). a job without a claim time gets now, so a worker that died
+	//before stamping it is still found on a later pass
+	Claimed(ctx context.Context, id string, now int64) (int64, error)
+	//how many times the job has been started, ErrNotFound once it has expired
+	Attempts(ctx context.Context, id string) (int, error)
+	//takes the id off the processing list, false when another instance already took it off
+	Release(ctx context.Context, id string) (bool, error)
+	//fails the job for good
+	Fail(ctx context.Context, id string) error
+	//marks the job queued again and puts it back on the queue
+	Requeue(ctx context.Context, id string) error
+}
+
+// puts jobs back on the queue when a worker took them more than timeout ago and they are still being
+// processed, their worker has most likely died. that includes jobs the worker never got to start. jobs
+// that have already had maxAttempts are failed instead. returns how many jobs were requeued and failed
+func RequeueStalled(ctx context.Context, store Store, now time.Time, timeout time.Duration, maxAttempts int) (int, int, error) {
+	ids, err := store.Processing(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	stalledBefore := now.Add(-timeout).Unix()
+
+	requeued, failed := 0, 0
+	for _, id := range ids {
+		attempts, err := store.Attempts(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			//expired jobs have nothing left to do
+			if _, err := store.Release(ctx, id); err != nil {
+				log.Println("Error releasing expired valuation job "+id+":", err)
+			}
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		claimedAt, err := store.Claimed(ctx, id, now.Unix())
+		if err != nil || claimedAt > stalledBefore {
+			continue
+		}
+
+		//only the instance that takes the id off requeues it
+		released, err := store.Release(ctx, id)
+		if err != nil || !released {
+			continue
+		}
+
+		if attempts >= maxAttempts {
+			if err := store.Fail(ctx, id); err != nil {
+				log.Println("Error failing valuation job "+id+":", err)
+				continue
+			}
+			failed++
+			continue
+		}
+
+		if err := store.Requeue(ctx, id); err != nil {
+			log.Println("Error requeueing valuation job "+id+":", err)
+			continue
+		}
+		requeued++
+	}
+
+	return requeued, failed, nil
+}
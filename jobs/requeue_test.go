@@ -0,0 +1,167 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+type fakeJob struct {
+	startedAt int64
+	attempts  int
+	status    string
+}
+
+// an in memory queue, released lists ids another instance has already taken off processing
+type fakeStore struct {
+	jobs       map[string]*fakeJob
+	processing []string
+	claims     map[string]int64
+	queue      []string
+	released   map[string]bool
+}
+
+func (s *fakeStore) Processing(ctx context.Context) ([]string, error) {
+	return append([]string{}, s.processing...), nil
+}
+
+func (s *fakeStore) Claimed(ctx context.Context, id string, now int64) (int64, error) {
+	if _, ok := s.claims[id]; !ok {
+		s.claims[id] = now
+	}
+	return s.claims[id], nil
+}
+
+func (s *fakeStore) Attempts(ctx context.Context, id string) (int, error) {
+	job, ok := s.jobs[id]
+	if !ok {
+		return 0, ErrNotFound
+	}
+	return job.attempts, nil
+}
+
+func (s *fakeStore) Release(ctx context.Context, id string) (bool, error) {
+	if s.released[id] {
+		return false, nil
+	}
+	for i, processing := range s.processing {
+		if processing == id {
+			s.processing = append(s.processing[:i], s.processing[i+1:]...)
+			delete(s.claims, id)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (s *fakeStore) Fail(ctx context.Context, id string) error {
+	s.jobs[id].status = "failed"
+	return nil
+}
+
+func (s *fakeStore) Requeue(ctx context.Context, id string) error {
+	s.jobs[id].status = "queued"
+	s.jobs[id].startedAt = 0
+	s.queue = append(s.queue, id)
+	return nil
+}
+
+const timeout = 3 * time.Minute
+
+func TestRequeueStalled(t *testing.T) {
+	now := time.Unix(10000, 0)
+	stalled := now.Add(-timeout - time.Second).Unix()
+
+	store := &fakeStore{
+		jobs: map[string]*fakeJob{
+			"stalled":       {startedAt: stalled, attempts: 1, status: "running"},
+			"running":       {startedAt: now.Unix() - 10, attempts: 1, status: "running"},
+			"never started": {startedAt: 0, attempts: 0, status: "queued"},
+			"keeps failing": {startedAt: stalled, attempts: 3, status: "running"},
+			"taken":         {startedAt: stalled, attempts: 1, status: "running"},
+		},
+		processing: []string{"stalled", "running", "never started", "keeps failing", "taken", "expired"},
+		claims: map[string]int64{
+			"stalled":       stalled,
+			"running":       now.Unix() - 10,
+			"never started": stalled,
+			"keeps failing": stalled,
+			"taken":         stalled,
+		},
+		released: map[string]bool{"taken": true},
+	}
+
+	requeued, failed, err := RequeueStalled(context.Background(), store, now, timeout, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued != 2 || failed != 1 {
+		t.Errorf("requeued %d and failed %d, want 2 and 1", requeued, failed)
+	}
+
+	//a worker that died between taking a job and starting it doesn't strand the job
+	sort.Strings(store.queue)
+	if want := []string{"never started", "stalled"}; !reflect.DeepEqual(store.queue, want) {
+		t.Errorf("queue is %v, want %v", store.queue, want)
+	}
+	if job := store.jobs["stalled"]; job.status != "queued" || job.startedAt != 0 {
+		t.Errorf("stalled job is %+v, want it queued and not started", *job)
+	}
+	if status := store.jobs["keeps failing"].status; status != "failed" {
+		t.Errorf("a job out of attempts is %s, want failed", status)
+	}
+	if status := store.jobs["taken"].status; status != "running" {
+		t.Errorf("a job another instance took is %s, want it left alone", status)
+	}
+
+	//jobs still within their time stay, expired ones are dropped
+	sort.Strings(store.processing)
+	if want := []string{"running", "taken"}; !reflect.DeepEqual(store.processing, want) {
+		t.Errorf("processing is %v, want %v", store.processing, want)
+	}
+}
+
+// the worker died before it could stamp when it took the job
+func TestRequeueStalledWithoutClaim(t *testing.T) {
+	now := time.Unix(10000, 0)
+	store := &fakeStore{
+		jobs:       map[string]*fakeJob{"unclaimed": {status: "queued"}},
+		processing: []string{"unclaimed"},
+		claims:     map[string]int64{},
+	}
+
+	//the first pass only stamps it
+	if requeued, _, err := RequeueStalled(context.Background(), store, now, timeout, 3); err != nil || requeued != 0 {
+		t.Fatalf("requeued %d (%v) on the first pass, want 0", requeued, err)
+	}
+	if store.claims["unclaimed"] != now.Unix() {
+		t.Fatalf("claim is %d, want it stamped with %d", store.claims["unclaimed"], now.Unix())
+	}
+
+	//and a pass after the timeout requeues it
+	requeued, _, err := RequeueStalled(context.Background(), store, now.Add(timeout+time.Minute), timeout, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if requeued != 1 || !reflect.DeepEqual(store.queue, []string{"unclaimed"}) {
+		t.Errorf("requeued %d, queue is %v, want the unclaimed job back on the queue", requeued, store.queue)
+	}
+}
+
+type failingStore struct {
+	fakeStore
+}
+
+func (s *failingStore) Processing(ctx context.Context) ([]string, error) {
+	return nil, errors.New("connection refused")
+}
+
+func TestRequeueStalledListError(t *testing.T) {
+	_, _, err := RequeueStalled(context.Background(), &failingStore{}, time.Now(), timeout, 3)
+	if err == nil {
+		t.Errorf("expected the error listing jobs to be returned")
+	}
+}
@@ -0,0 +1,50 @@
+// Package jobs has the parts of the valuation job queue that don't need redis, signing and delivering
+// webhooks and deciding which stalled jobs to requeue.
+package jobs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// webhooks can only reach public addresses, so a callback url can't be used to call into our network
+var WebhookClient = &http.Client{
+	Timeout: 10 * time.Second,
+	Transport: &http.Transport{
+		DialContext: (&net.Dialer{Timeout: 5 * time.Second, Control: PublicAddressOnly}).DialContext,
+	},
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// dialer control that refuses private, loopback, link local and multicast addresses. it runs after the
+// name is resolved so a public name pointing at a private address is refused too
+func PublicAddressOnly(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() || ip.IsMulticast() {
+		return fmt.Errorf("%s is not a public address", host)
+	}
+	return nil
+}
+
+// signs a webhook body the way stripe does, the customer checks
+// hex(hmac_sha256(secret, timestamp + "." + body)) against v1 in the X-Valuation-Signature header
+func SignWebhook(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "t=" + strconv.FormatInt(timestamp, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
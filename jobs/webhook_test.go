@@ -0,0 +1,73 @@
+package jobs
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// checks a signature header the way the docs tell customers to
+func verify(header string, secret string, body []byte) bool {
+	timestamp, signature, ok := strings.Cut(header, ",v1=")
+	if !ok || !strings.HasPrefix(timestamp, "t=") {
+		return false
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.TrimPrefix(timestamp, "t=") + "." + string(body)))
+	return hmac.Equal([]byte(signature), []byte(hex.EncodeToString(mac.Sum(nil))))
+}
+
+func TestSignWebhook(t *testing.T) {
+	body := []byte(`{"id":"abc","status":"succeeded"}`)
+	header := SignWebhook("whsec_test", 1700000000, body)
+
+	if !strings.HasPrefix(header, "t=1700000000,v1=") {
+		t.Fatalf("header is %s, want it to start with t=1700000000,v1=", header)
+	}
+	if !verify(header, "whsec_test", body) {
+		t.Errorf("%s doesn't verify", header)
+	}
+
+	if verify(header, "whsec_other", body) {
+		t.Errorf("the signature verifies with the wrong secret")
+	}
+	if verify(header, "whsec_test", []byte(`{"id":"abc","status":"failed"}`)) {
+		t.Errorf("the signature verifies for a different body")
+	}
+	if SignWebhook("whsec_test", 1700000001, body) == header {
+		t.Errorf("the signature doesn't depend on the timestamp")
+	}
+}
+
+func TestPublicAddressOnly(t *testing.T) {
+	tests := []struct {
+		address string
+		public  bool
+	}{
+		{"93.184.216.34:443", true},
+		{"[2606:2800:220:1:248:1893:25c8:1946]:443", true},
+		{"127.0.0.1:80", false},
+		{"[::1]:80", false},
+		{"10.0.0.5:443", false},
+		{"172.16.3.4:443", false},
+		{"192.168.1.1:80", false},
+		{"[fd00::1]:443", false},
+		//cloud metadata endpoints are link local
+		{"169.254.169.254:80", false},
+		{"[fe80::1]:80", false},
+		{"0.0.0.0:80", false},
+		{"224.0.0.1:80", false},
+		{"example.com:80", false},
+		{"93.184.216.34", false},
+	}
+
+	for _, test := range tests {
+		err := PublicAddressOnly("tcp", test.address, nil)
+		if (err == nil) != test.public {
+			t.Errorf("%s: got error %v, want public %t", test.address, err, test.public)
+		}
+	}
+}
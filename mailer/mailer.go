@@ -0,0 +1,83 @@
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+var htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/*.html"))
+var textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/*.txt"))
+
+type Message struct {
+	To      string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// sends emails, use NewSMTPMailer in production and NewFileMailer or NewLogMailer locally
+type Mailer interface {
+	Send(message Message) error
+}
+
+// renders [name].txt and [name].html from the templates folder into a message
+func Render(name string, to string, subject string, data interface{}) (Message, error) {
+	message := Message{To: to, Subject: subject}
+
+	var text bytes.Buffer
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return message, err
+	}
+	message.Text = text.String()
+
+	var html bytes.Buffer
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return message, err
+	}
+	message.HTML = html.String()
+
+	return message, nil
+}
+
+// logs emails instead of sending them
+type LogMailer struct{}
+
+func NewLogMailer() *LogMailer {
+	return &LogMailer{}
+}
+
+func (m *LogMailer) Send(message Message) error {
+	log.Println("Email to " + message.To + ": " + message.Subject + "\n" + message.Text)
+	return nil
+}
+
+// writes each email to its own .eml file in a folder
+type FileMailer struct {
+	Dir  string
+	From string
+}
+
+func NewFileMailer(dir string, from string) *FileMailer {
+	return &FileMailer{Dir: dir, From: from}
+}
+
+func (m *FileMailer) Send(message Message) error {
+	if err := os.MkdirAll(m.Dir, 0755); err != nil {
+		return err
+	}
+
+	name := strconv.FormatInt(time.Now().UnixNano(), 10) + "-" + strings.ReplaceAll(message.To, "@", "_at_") + ".eml"
+
+	return os.WriteFile(filepath.Join(m.Dir, name), buildMIME(m.From, message), 0644)
+}
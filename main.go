@@ -1,9 +1,11 @@
 package main
 
 import (
+	"vehicle-api/billing"
 	"vehicle-api/configs"
 	"vehicle-api/middlewares"
 	"vehicle-api/routes"
+	"vehicle-api/utils"
 
 	"github.com/goccy/go-json"
 	"github.com/gofiber/fiber/v2"
@@ -26,6 +28,21 @@ func main() {
 	//run database
 	configs.ConnectDB()
 
+	//hash any api keys still stored in plaintext
+	go utils.MigratePlaintextKeys()
+
+	//remove keys from the urls of calls logged before they were redacted
+	go utils.RedactLoggedApiKeys()
+
+	//drop VINs cached by an older version of the decoder
+	go utils.RemoveStaleVinCache()
+
+	//report metered usage to stripe in the background
+	billing.Start()
+
+	//run queued valuation jobs in the background
+	utils.StartValuationWorkers()
+
 	//middlewares
 	app.Use(logger.New())
 	app.Get("/metrics", monitor.New())
@@ -45,6 +62,29 @@ func main() {
 	valuationRoutes.Use(middlewares.ValuationMiddleware)
 	routes.ValuationRoutes(app)
 
+	//vin routes should use vin middleware
+	vinRoutes := api.Group("/vin")
+	vinRoutes.Use(middlewares.VinMiddleware)
+	routes.VinRoutes(app)
+
+	//account = signup and account routes for the dashboard, protected from csrf since they use the session cookie
+	accountRoutes := app.Group("/account")
+	accountRoutes.Use(middlewares.CsrfMiddleware)
+	routes.UserRoutes(app)
+
+	//account/keys = api key management for logged in users
+	keyRoutes := app.Group("/account/keys")
+	keyRoutes.Use(middlewares.UserMiddleware)
+	routes.KeyRoutes(app)
+
+	//account/usage = call analytics for logged in users
+	usageRoutes := app.Group("/account/usage")
+	usageRoutes.Use(middlewares.UserMiddleware)
+	routes.UsageRoutes(app)
+
+	//webhooks = signed callbacks from third parties, no session or key
+	routes.WebhookRoutes(app)
+
 	//admin-api = admin api routes for staff admins
 	adminApi := app.Group("/admin-api")
 	adminApi.Use(middlewares.AdminMiddleware)
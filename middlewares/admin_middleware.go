@@ -1,10 +1,55 @@
 package middlewares
 
 import (
+	"context"
+	"net/http"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
 	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
+var adminCollection *mongo.Collection = configs.GetCollection(configs.DB, "admins")
+
 func AdminMiddleware(c *fiber.Ctx) error {
+	//login is the only admin route that doesn't need a session
+	if c.Path() == "/admin-api/login" {
+		return c.Next()
+	}
+
+	session, err := configs.GetSession().Get(c)
+	if err != nil {
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	//admin sessions only store the admin id
+	adminID, ok := session.Get("admin").(string)
+	if !ok || adminID == "" {
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Unauthorized"}})
+	}
+
+	objectID, err := primitive.ObjectIDFromHex(adminID)
+	if err != nil {
+		session.Destroy()
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Unauthorized"}})
+	}
+
+	//verify admin still exists
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	var admin models.Admin
+	if err := adminCollection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&admin); err != nil {
+		session.Destroy()
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Unauthorized"}})
+	}
+
+	c.Locals("admin", admin)
 
 	return c.Next()
 }
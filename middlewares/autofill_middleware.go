@@ -2,6 +2,7 @@ package middlewares
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"time"
 	"vehicle-api/configs"
@@ -67,10 +68,9 @@ func AutofillMiddleware(c *fiber.Ctx) error {
 	//verify key for each host
 	//don't need to verify organization is active bc keys are set to inactive when organization is set to inactive
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	var key models.Key
 	defer cancel()
 
-	err := keyCollection.FindOne(ctx, bson.M{"key": keyString, "is_active": true, "routes": route}).Decode(&key)
+	key, err := findKey(ctx, keyString, route)
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -84,8 +84,46 @@ func AutofillMiddleware(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Invalid key"}})
 	}
 
-	// log call with logger function
-	go utils.LogCall(key, c.OriginalURL(), route)
+	if allowed, err := rateLimit(c, key, route); !allowed {
+		return err
+	}
+
+	// log call with logger function once the handler has responded
+	return nextAndLogCall(c, key, route)
+}
+
+// finds an active key for the route. prefixed keys are found by prefix and the secret is checked
+// against the stored hash, legacy keys are found by the hash of the whole key
+func findKey(ctx context.Context, keyString string, route string) (models.Key, error) {
+	var key models.Key
+
+	if prefix, secret, ok := utils.ParseApiKey(keyString); ok {
+		err := keyCollection.FindOne(ctx, bson.M{"prefix": prefix, "is_active": true, "routes": route}).Decode(&key)
+		if err != nil {
+			return key, err
+		}
+
+		if !utils.CompareApiKeyHash(secret, key.Hash) {
+			return models.Key{}, mongo.ErrNoDocuments
+		}
+
+		return key, nil
+	}
+
+	err := keyCollection.FindOne(ctx, bson.M{"hash": utils.HashApiKey(keyString), "is_active": true, "routes": route}).Decode(&key)
+	if err != mongo.ErrNoDocuments {
+		return key, err
+	}
+
+	//key may not have been migrated yet, hash it now so the plaintext lookup only happens once
+	err = keyCollection.FindOne(ctx, bson.M{"key": keyString, "is_active": true, "routes": route}).Decode(&key)
+	if err != nil {
+		return key, err
+	}
+
+	if err := utils.MigratePlaintextKey(ctx, key); err != nil {
+		log.Println("Error migrating key "+key.ID.Hex()+":", err)
+	}
 
-	return c.Next()
+	return key, nil
 }
@@ -0,0 +1,38 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/csrf"
+)
+
+const csrfCookieName = "csrf_"
+
+// double submit csrf protection for the cookie based session. safe requests get a token cookie,
+// every other request has to send the same token back in the X-Csrf-Token header. tokens are kept in redis
+// next to the sessions so they survive restarts and work on every replica
+var CsrfMiddleware = csrf.New(csrf.Config{
+	KeyLookup:      "header:" + csrf.HeaderName,
+	CookieName:     csrfCookieName,
+	CookieSameSite: "Lax",
+	CookieHTTPOnly: false,
+	Expiration:     12 * time.Hour,
+	ContextKey:     "csrf",
+	Storage:        configs.CsrfStorage,
+	Extractor: func(c *fiber.Ctx) (string, error) {
+		//the token also has to match the cookie, not just exist
+		token := c.Get(csrf.HeaderName)
+		if token == "" || token != c.Cookies(csrfCookieName) {
+			return "", errors.New("csrf token mismatch")
+		}
+		return token, nil
+	},
+	ErrorHandler: func(c *fiber.Ctx, err error) error {
+		return c.Status(http.StatusForbidden).JSON(utils.ApiResponse{Status: http.StatusForbidden, Message: "error", Data: &fiber.Map{"data": "Invalid CSRF token"}})
+	},
+})
@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"strings"
+	"time"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// runs the handler and then logs the call with its response status and latency
+func nextAndLogCall(c *fiber.Ctx, key models.Key, route string) error {
+	start := time.Now()
+
+	err := c.Next()
+
+	status := c.Response().StatusCode()
+	if err != nil {
+		//the error handler hasn't written the response yet, use the status it will write
+		status = fiber.StatusInternalServerError
+		if e, ok := err.(*fiber.Error); ok {
+			status = e.Code
+		}
+	}
+
+	//fiber reuses the request buffer once the handler returns, copy the url before handing it off
+	go utils.LogCall(key, strings.Clone(c.OriginalURL()), route, status, time.Since(start))
+
+	return err
+}
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+	"vehicle-api/models"
+	"vehicle-api/utils"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// applies the key's limits for the route and sets the rate limit headers.
+// returns false if the call was rejected, in which case the 429 response has already been written
+func rateLimit(c *fiber.Ctx, key models.Key, route string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+
+	result, err := utils.CheckRateLimit(ctx, key, route)
+	if err != nil {
+		//don't take the api down with redis, let the call through
+		log.Println("Error checking rate limit:", err)
+		return true, nil
+	}
+
+	if result.Limit > 0 {
+		c.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+		c.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		c.Set("X-RateLimit-Reset", strconv.FormatInt(result.Reset, 10))
+	}
+
+	if result.QuotaLimit > 0 {
+		c.Set("X-RateLimit-Quota-Limit", strconv.Itoa(result.QuotaLimit))
+		c.Set("X-RateLimit-Quota-Remaining", strconv.Itoa(result.QuotaRemaining))
+		c.Set("X-RateLimit-Quota-Reset", strconv.FormatInt(result.QuotaReset, 10))
+	}
+
+	if !result.Allowed {
+		c.Set("Retry-After", strconv.FormatInt(result.RetryAfter, 10))
+
+		message := "Rate limit exceeded"
+		if result.QuotaExceeded {
+			message = "Monthly quota exceeded"
+		}
+
+		return false, c.Status(http.StatusTooManyRequests).JSON(utils.ApiResponse{Status: http.StatusTooManyRequests, Message: "error", Data: &fiber.Map{"data": message}})
+	}
+
+	return true, nil
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/gofiber/fiber/v2"
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 )
 
@@ -23,24 +24,28 @@ func UserMiddleware(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": err.Error()}})
 	}
 
-	//retrieve user from session
-	if session.Get("user") == nil {
+	//retrieve user id from session
+	sessionUser, _ := session.Get("user").(string)
+	userID, err := primitive.ObjectIDFromHex(sessionUser)
+	if err != nil {
 		session.Destroy()
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "User not found"}})
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "User not found"}})
 	}
 
-	var foundUser models.User = session.Get("user").(models.User)
-
 	//get user from db
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	var user models.User
-	err = userCollection.FindOne(ctx, bson.M{"_id": foundUser.ID}).Decode(&user)
+	err = userCollection.FindOne(ctx, bson.M{"_id": userID}).Decode(&user)
 	defer cancel()
 
 	if err != nil {
 		session.Destroy()
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "User not found"}})
 	}
 
+	//make the fresh user available to the handlers
+	c.Locals("user", user)
+	c.Locals("session_id", session.ID())
+
 	return c.Next()
 }
@@ -3,13 +3,12 @@ package middlewares
 import (
 	"context"
 	"net/http"
+	"strings"
 	"time"
 	"vehicle-api/configs"
-	"vehicle-api/models"
 	"vehicle-api/utils"
 
 	"github.com/gofiber/fiber/v2"
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/exp/slices"
 )
@@ -25,15 +24,23 @@ func ValuationMiddleware(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Key is required"}})
 	}
 
-	//verify query params exist
-	vin := c.Query("vin")
-	if vin == "" {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "VIN is required"}})
+	//the valuation itself is validated by the controllers, which also accept it as a json body
+	//polling a job, listing comparables and sending a batch are logged and limited separately. polls aren't
+	//billed, comparables are billed per call and batches per row. creating a job is limited like a valuation
+	//but logged on its own, the worker logs the valuation once it has run
+	route := "valuation"
+	switch {
+	case c.Method() == fiber.MethodGet && strings.HasPrefix(c.Path(), "/api/v1/valuation/jobs/"):
+		route = "valuation_jobs"
+	case c.Method() == fiber.MethodGet && c.Path() == "/api/v1/valuation/comparables":
+		route = "valuation_comparables"
+	case c.Method() == fiber.MethodPost && c.Path() == "/api/v1/valuation/batch":
+		route = "valuation_batch"
 	}
 
-	zipCode := c.Query("zip_code")
-	if zipCode == "" {
-		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "Zip code is required"}})
+	logRoute := route
+	if c.Method() == fiber.MethodPost && c.Path() == "/api/v1/valuation/jobs" {
+		logRoute = "valuation_job_create"
 	}
 
 	if rapidAPI == configs.RetrieveEnv("RAPID_API_SECRET_VALUATION") {
@@ -43,10 +50,9 @@ func ValuationMiddleware(c *fiber.Ctx) error {
 	//verify key for each host
 	//don't need to verify organization is active bc keys are set to inactive when organization is set to inactive
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	var key models.Key
 	defer cancel()
 
-	err := keyCollection.FindOne(ctx, bson.M{"key": keyString, "is_active": true, "routes": "valuation"}).Decode(&key)
+	key, err := findKey(ctx, keyString, "valuation")
 
 	if err != nil {
 		if err == mongo.ErrNoDocuments {
@@ -60,8 +66,13 @@ func ValuationMiddleware(c *fiber.Ctx) error {
 		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Invalid key"}})
 	}
 
-	// log call with logger function
-	go utils.LogCall(key, c.OriginalURL(), "valuation")
+	if allowed, err := rateLimit(c, key, route); !allowed {
+		return err
+	}
+
+	//jobs are owned by the key's user
+	c.Locals("key", &key)
 
-	return c.Next()
+	// log call with logger function once the handler has responded
+	return nextAndLogCall(c, key, logRoute)
 }
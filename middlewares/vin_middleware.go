@@ -0,0 +1,65 @@
+package middlewares
+
+import (
+	"context"
+	"net/http"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/utils"
+	"vehicle-api/vin"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/mongo"
+	"golang.org/x/exp/slices"
+)
+
+func VinMiddleware(c *fiber.Ctx) error {
+	host := c.Hostname()
+	keyString := c.Query("key")
+	// get X-RapidAPI-Proxy-Secret header from request
+	rapidAPI := c.Get("X-RapidAPI-Proxy-Secret")
+
+	//verify request has key
+	if keyString == "" && rapidAPI == "" {
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Key is required"}})
+	}
+
+	//verify query params exist
+	if c.Query("vin") == "" {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": "VIN is required"}})
+	}
+
+	//reject VINs that can't be valid before they reach vPIC
+	if _, err := vin.Parse(c.Query("vin")); err != nil {
+		return c.Status(http.StatusBadRequest).JSON(utils.ApiResponse{Status: http.StatusBadRequest, Message: "error", Data: &fiber.Map{"data": err.Error()}})
+	}
+
+	if secret := configs.RetrieveEnv("RAPID_API_SECRET_VIN"); secret != "" && rapidAPI == secret {
+		return c.Next()
+	}
+
+	//verify key for each host
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	key, err := findKey(ctx, keyString, "vin")
+
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Invalid key"}})
+		}
+		return c.Status(http.StatusInternalServerError).JSON(utils.ApiResponse{Status: http.StatusInternalServerError, Message: "error", Data: &fiber.Map{"data": "Something went wrong. Please try again later."}})
+	}
+
+	// if key has a list of authorized domains and the host is not in the list, return unauthorized, else continue
+	if len(key.AuthorizedDomains) > 0 && !slices.Contains(key.AuthorizedDomains, host) {
+		return c.Status(http.StatusUnauthorized).JSON(utils.ApiResponse{Status: http.StatusUnauthorized, Message: "error", Data: &fiber.Map{"data": "Invalid key"}})
+	}
+
+	if allowed, err := rateLimit(c, key, "vin"); !allowed {
+		return err
+	}
+
+	// log call with logger function once the handler has responded
+	return nextAndLogCall(c, key, "vin")
+}
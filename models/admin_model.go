@@ -4,8 +4,8 @@ import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type Admin struct {
 	ID        primitive.ObjectID `bson:"_id,omitempty"`
-	FirstName string             `json:"first_name,omitempty" validate:"required"`
-	LastName  string             `json:"last_name,omitempty" validate:"required"`
-	Email     string             `json:"email,omitempty" validate:"required"`
-	Password  string             `json:"password,omitempty" validate:"required"`
+	FirstName string             `bson:"first_name" json:"first_name,omitempty" validate:"required"`
+	LastName  string             `bson:"last_name" json:"last_name,omitempty" validate:"required"`
+	Email     string             `bson:"email" json:"email,omitempty" validate:"required"`
+	Password  string             `bson:"password" json:"password,omitempty" validate:"required"`
 }
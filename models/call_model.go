@@ -6,8 +6,13 @@ type Call struct {
 	ID             primitive.ObjectID `bson:"_id,omitempty"`
 	User           primitive.ObjectID `bson:"user,omitempty" validate:"required"`
 	Key            primitive.ObjectID `bson:"key,omitempty" validate:"required"`
-	RequestURL     string             `json:"request_url,omitempty" validate:"required"`
-	ResponseStatus int                `json:"response_status,omitempty"`
-	ResponseTime   int                `json:"response_time,omitempty"`
-	CreatedAt      int64              `json:"created_at,omitempty" validate:"required"`
+	Route          string             `bson:"route" json:"route,omitempty"`
+	RequestURL     string             `bson:"request_url" json:"request_url,omitempty" validate:"required"`
+	ResponseStatus int                `bson:"response_status" json:"response_status,omitempty"`
+	ResponseTime   int                `bson:"response_time" json:"response_time,omitempty"`
+	CreatedAt      int64              `bson:"created_at" json:"created_at,omitempty" validate:"required"`
+	UsageReport    primitive.ObjectID `bson:"usage_report,omitempty" json:"usage_report,omitempty"`
 }
+
+//ResponseTime is in milliseconds
+//UsageReport is set once a billable call has been added to a usage report
@@ -5,10 +5,51 @@ import "go.mongodb.org/mongo-driver/bson/primitive"
 type Key struct {
 	ID                primitive.ObjectID `bson:"_id,omitempty"`
 	User              primitive.ObjectID `bson:"user,omitempty" validate:"required"`
-	Key               string             `json:"key,omitempty" validate:"required"`
-	Routes            []string           `json:"routes,omitempty" validate:"required"`
-	AuthorizedDomains []string           `json:"authorized_domains"`
-	IsActive          bool               `json:"is_active,omitempty"`
+	Name              string             `bson:"name" json:"name,omitempty"`
+	Key               string             `bson:"key,omitempty" json:"-"`
+	Prefix            string             `bson:"prefix,omitempty" json:"prefix,omitempty"`
+	Hash              string             `bson:"hash,omitempty" json:"-"`
+	Routes            []string           `bson:"routes" json:"routes,omitempty" validate:"required"`
+	AuthorizedDomains []string           `bson:"authorized_domains" json:"authorized_domains"`
+	IsActive          bool               `bson:"is_active" json:"is_active,omitempty"`
+	DisabledReason    string             `bson:"disabled_reason,omitempty" json:"disabled_reason,omitempty"`
+	Limits            map[string]Limit   `bson:"limits,omitempty" json:"limits,omitempty"`
+	CreatedAt         int64              `bson:"created_at" json:"created_at,omitempty"`
 }
 
 //authorizedDomains are only used for autofill routes. If it is left emty, then all domains are valid
+//Key is the legacy plaintext key, new keys only store Prefix and Hash and legacy keys are hashed on first use
+//DisabledReason is set when a key is deactivated by the system (e.g. "payment") so it can be reactivated automatically
+//Limits overrides the default limits for a route, routes without an entry use the defaults
+
+type Limit struct {
+	PerSecond int `bson:"per_second" json:"per_second"`
+	PerMonth  int `bson:"per_month" json:"per_month"`
+}
+
+// a limit of 0 means unlimited. valuations are billed per call so they have no default monthly quota,
+// a quota can be set per key through Limits for any route here, comparables included
+var DefaultLimits = map[string]Limit{
+	"years":     {PerSecond: 20, PerMonth: 0},
+	"makes":     {PerSecond: 20, PerMonth: 0},
+	"models":    {PerSecond: 20, PerMonth: 0},
+	"trims":     {PerSecond: 20, PerMonth: 0},
+	"valuation": {PerSecond: 2, PerMonth: 0},
+	"vin":       {PerSecond: 10, PerMonth: 0},
+
+	//polling valuation jobs, listing comparables and sending batches, keys are scoped to them through "valuation"
+	"valuation_jobs":        {PerSecond: 10, PerMonth: 0},
+	"valuation_comparables": {PerSecond: 2, PerMonth: 0},
+	"valuation_batch":       {PerSecond: 1, PerMonth: 0},
+}
+
+// routes a key can be scoped to, these match the route names checked by the key middlewares
+var KeyRoutes = []string{"years", "makes", "models", "trims", "valuation", "vin"}
+
+// returns the key's limit for a route, falling back to the default limit
+func (key Key) LimitFor(route string) Limit {
+	if limit, ok := key.Limits[route]; ok {
+		return limit
+	}
+	return DefaultLimits[route]
+}
@@ -0,0 +1,27 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type UsageReport struct {
+	ID                 primitive.ObjectID `bson:"_id,omitempty"`
+	User               primitive.ObjectID `bson:"user,omitempty" validate:"required"`
+	SubscriptionItemID string             `bson:"subscription_item_id" json:"subscription_item_id,omitempty"`
+	Route              string             `bson:"route" json:"route,omitempty"`
+	Quantity           int64              `bson:"quantity" json:"quantity"`
+	Status             string             `bson:"status" json:"status,omitempty"`
+	Attempts           int                `bson:"attempts" json:"attempts"`
+	LastError          string             `bson:"last_error,omitempty" json:"last_error,omitempty"`
+	StripeUsageID      string             `bson:"stripe_usage_id,omitempty" json:"stripe_usage_id,omitempty"`
+	NextAttemptAt      int64              `bson:"next_attempt_at" json:"next_attempt_at,omitempty"`
+	CreatedAt          int64              `bson:"created_at" json:"created_at,omitempty"`
+	ReportedAt         int64              `bson:"reported_at,omitempty" json:"reported_at,omitempty"`
+}
+
+//a usage report is one batch of billable calls sent to stripe as a single usage record
+//the report id is used as the stripe idempotency key so retries never double bill
+
+const (
+	UsageReportPending  = "pending"
+	UsageReportReported = "reported"
+	UsageReportFailed   = "failed"
+)
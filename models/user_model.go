@@ -4,20 +4,31 @@ import "go.mongodb.org/mongo-driver/bson/primitive"
 
 type User struct {
 	ID                     primitive.ObjectID   `bson:"_id,omitempty"`
-	FirstName              string               `json:"first_name,omitempty" validate:"required"`
-	LastName               string               `json:"last_name,omitempty" validate:"required"`
-	Email                  string               `json:"email,omitempty" validate:"required"`
-	Password               string               `json:"password,omitempty" validate:"required"`
+	FirstName              string               `bson:"first_name" json:"first_name,omitempty" validate:"required"`
+	LastName               string               `bson:"last_name" json:"last_name,omitempty" validate:"required"`
+	Email                  string               `bson:"email" json:"email,omitempty" validate:"required"`
+	Password               string               `bson:"password" json:"password,omitempty" validate:"required"`
 	Keys                   []primitive.ObjectID `bson:"keys"`
-	CreatedAt              int64                `json:"created_at,omitempty"`
-	IsVerified             bool                 `json:"is_verified"`
-	EmailToken             string               `json:"email_token,omitempty"`
-	EmailTokenExpiry       int64                `json:"email_token_expiry,omitempty"`
-	ResetToken             string               `json:"reset_token,omitempty"`
-	ResetTokenExpiry       int64                `json:"reset_token_expiry,omitempty"`
-	IsActive               bool                 `json:"is_active,omitempty"`
-	StripeCustomerID       string               `json:"stripe_customer_id,omitempty"`
-	PaymentMethodID        string               `json:"payment_method_id,omitempty"`
-	SetupIntentID          string               `json:"setup_intent_id,omitempty"`
-	AutofillSubscriptionID string               `json:"autofill_subscription_id,omitempty"`
+	CreatedAt              int64                `bson:"created_at" json:"created_at,omitempty"`
+	IsVerified             bool                 `bson:"is_verified" json:"is_verified"`
+	EmailToken             string               `bson:"email_token" json:"email_token,omitempty"`
+	EmailTokenExpiry       int64                `bson:"email_token_expiry" json:"email_token_expiry,omitempty"`
+	ResetToken             string               `bson:"reset_token" json:"reset_token,omitempty"`
+	ResetTokenExpiry       int64                `bson:"reset_token_expiry" json:"reset_token_expiry,omitempty"`
+	IsActive               bool                 `bson:"is_active" json:"is_active,omitempty"`
+	DisabledReason         string               `bson:"disabled_reason,omitempty" json:"disabled_reason,omitempty"`
+	StripeCustomerID       string               `bson:"stripe_customer_id" json:"stripe_customer_id,omitempty"`
+	PaymentMethodID        string               `bson:"payment_method_id" json:"payment_method_id,omitempty"`
+	SetupIntentID          string               `bson:"setup_intent_id" json:"setup_intent_id,omitempty"`
+	AutofillSubscriptionID string               `bson:"autofill_subscription_id" json:"autofill_subscription_id,omitempty"`
+	ValuationItemID        string               `bson:"valuation_item_id" json:"valuation_item_id,omitempty"`
 }
+
+//DisabledReason says why an inactive account is inactive, only accounts waiting on payment are activated by stripe
+//ValuationItemID is the stripe subscription item that metered valuation usage is reported to
+
+// why an account or key was deactivated
+const (
+	DisabledPayment = "payment"
+	DisabledAdmin   = "admin"
+)
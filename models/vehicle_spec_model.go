@@ -0,0 +1,41 @@
+package models
+
+// vehicle specs decoded from a VIN. fields vPIC doesn't have for a vehicle are left empty
+type VehicleSpec struct {
+	VIN          string     `json:"vin"`
+	Year         int        `json:"year"`
+	Make         string     `json:"make"`
+	Model        string     `json:"model"`
+	Series       string     `json:"series,omitempty"`
+	Trim         string     `json:"trim,omitempty"`
+	Manufacturer string     `json:"manufacturer,omitempty"`
+	VehicleType  string     `json:"vehicle_type,omitempty"`
+	BodyClass    string     `json:"body_class,omitempty"`
+	Doors        int        `json:"doors,omitempty"`
+	DriveType    string     `json:"drive_type,omitempty"`
+	FuelType     string     `json:"fuel_type,omitempty"`
+	Transmission string     `json:"transmission,omitempty"`
+	Engine       EngineSpec `json:"engine"`
+	Plant        PlantSpec  `json:"plant"`
+	GVWR         string     `json:"gvwr,omitempty"`
+	Notes        string     `json:"notes,omitempty"`
+}
+
+//Series and Trim are reported separately by vPIC, most manufacturers only fill in one of them
+//GVWR is the gross vehicle weight rating class, e.g. "Class 1: 6,000 lb or less (2,722 kg or less)"
+//Notes holds vPIC's decode warnings when the VIN could only be partially decoded
+
+type EngineSpec struct {
+	Cylinders      int     `json:"cylinders,omitempty"`
+	DisplacementL  float64 `json:"displacement_l,omitempty"`
+	DisplacementCC float64 `json:"displacement_cc,omitempty"`
+	Horsepower     float64 `json:"horsepower,omitempty"`
+	Configuration  string  `json:"configuration,omitempty"`
+	Model          string  `json:"model,omitempty"`
+}
+
+type PlantSpec struct {
+	Country string `json:"country,omitempty"`
+	State   string `json:"state,omitempty"`
+	City    string `json:"city,omitempty"`
+}
@@ -0,0 +1,19 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// one row of the vPIC pattern tables, sets Element to Value for VINs whose WMI and descriptor match
+type VinPattern struct {
+	ID       primitive.ObjectID `bson:"_id,omitempty" json:"-"`
+	WMI      string             `bson:"wmi" json:"wmi"`
+	YearFrom int                `bson:"year_from" json:"year_from"`
+	YearTo   int                `bson:"year_to" json:"year_to"`
+	Keys     string             `bson:"keys" json:"keys"`
+	Element  string             `bson:"element" json:"element"`
+	Value    string             `bson:"value" json:"value"`
+}
+
+//Keys is matched against positions 4-8 and 10-17 of the VIN joined with "|", "*" matches any character and
+//"[A-D]" a set of characters, a shorter pattern only has to match the start
+//YearTo is 0 for vehicles still in production
+//Element is the vPIC variable name, e.g. "Model" or "Body Class"
@@ -1,9 +1,28 @@
 package routes
 
 import (
+	"vehicle-api/controllers"
+
 	"github.com/gofiber/fiber/v2"
 )
 
 func AdminRoutes(app *fiber.App) {
+	app.Post("/admin-api/login", controllers.AdminLogin)
+	app.Post("/admin-api/logout", controllers.AdminLogout)
+
+	app.Get("/admin-api/users", controllers.ListUsers)
+	app.Get("/admin-api/users/:id", controllers.GetUser)
+	app.Patch("/admin-api/users/:id/status", controllers.UpdateUserStatus)
+	app.Get("/admin-api/users/:id/keys", controllers.ListUserKeys)
+	app.Get("/admin-api/users/:id/calls", controllers.ListUserCalls)
+	app.Get("/admin-api/users/:id/sessions", controllers.ListUserSessions)
+	app.Delete("/admin-api/users/:id/sessions", controllers.RevokeUserSessions)
+
+	app.Put("/admin-api/keys/:id/limits", controllers.UpdateKeyLimits)
+
+	app.Get("/admin-api/usage", controllers.GetAdminUsage)
 
+	app.Get("/admin-api/billing/reports", controllers.ListUsageReports)
+	app.Post("/admin-api/billing/reports/:id/retry", controllers.RetryUsageReport)
+	app.Get("/admin-api/billing/reconcile", controllers.ReconcileUsage)
 }
@@ -0,0 +1,14 @@
+package routes
+
+import (
+	"vehicle-api/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func KeyRoutes(app *fiber.App) {
+	app.Get("/account/keys", controllers.ListKeys)
+	app.Post("/account/keys", controllers.CreateKey)
+	app.Patch("/account/keys/:id", controllers.UpdateKey)
+	app.Delete("/account/keys/:id", controllers.DeleteKey)
+}
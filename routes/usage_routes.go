@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"vehicle-api/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func UsageRoutes(app *fiber.App) {
+	app.Get("/account/usage", controllers.GetUsage)
+}
@@ -0,0 +1,31 @@
+package routes
+
+import (
+	"vehicle-api/controllers"
+	"vehicle-api/middlewares"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func UserRoutes(app *fiber.App) {
+	//public
+	app.Get("/account/csrf", controllers.GetCsrfToken)
+	app.Post("/account/register", controllers.Register)
+	app.Post("/account/login", controllers.Login)
+	app.Post("/account/forgot-password", controllers.ForgotPassword)
+	app.Post("/account/reset-password", controllers.ResetPassword)
+	app.Post("/account/verify-email", controllers.VerifyEmail)
+	app.Post("/account/verify-email/resend", controllers.ResendVerificationEmail)
+
+	//session protected
+	app.Post("/account/logout", middlewares.UserMiddleware, controllers.Logout)
+	app.Get("/account/profile", middlewares.UserMiddleware, controllers.GetProfile)
+	app.Patch("/account/profile", middlewares.UserMiddleware, controllers.UpdateProfile)
+	app.Put("/account/password", middlewares.UserMiddleware, controllers.ChangePassword)
+	app.Post("/account/setup-intent", middlewares.UserMiddleware, controllers.CreateSetupIntent)
+	app.Delete("/account", middlewares.UserMiddleware, controllers.DeleteAccount)
+
+	app.Get("/account/sessions", middlewares.UserMiddleware, controllers.ListSessions)
+	app.Delete("/account/sessions", middlewares.UserMiddleware, controllers.RevokeOtherSessions)
+	app.Delete("/account/sessions/:id", middlewares.UserMiddleware, controllers.RevokeSession)
+}
@@ -8,4 +8,9 @@ import (
 
 func ValuationRoutes(app *fiber.App) {
 	app.Get("/api/v1/valuation", controllers.ValuationController)
+	app.Get("/api/v1/valuation/forecast", controllers.ValuationForecastController)
+	app.Get("/api/v1/valuation/comparables", controllers.ValuationComparablesController)
+	app.Post("/api/v1/valuation/batch", controllers.ValuationBatchController)
+	app.Post("/api/v1/valuation/jobs", controllers.CreateValuationJob)
+	app.Get("/api/v1/valuation/jobs/:id", controllers.GetValuationJob)
 }
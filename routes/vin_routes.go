@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"vehicle-api/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func VinRoutes(app *fiber.App) {
+	app.Get("/api/v1/vin/decode", controllers.DecodeVin)
+}
@@ -0,0 +1,11 @@
+package routes
+
+import (
+	"vehicle-api/controllers"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func WebhookRoutes(app *fiber.App) {
+	app.Post("/webhooks/stripe", controllers.StripeWebhook)
+}
@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"net/url"
+	"vehicle-api/configs"
+	"vehicle-api/mailer"
+	"vehicle-api/models"
+)
+
+// emails and password reset links expire after a day
+const emailTokenLifetime = 24 * 60 * 60
+
+func EmailTokenExpiry(now int64) int64 {
+	return now + emailTokenLifetime
+}
+
+// links point at the dashboard (APP_URL) which calls the api with the token
+func SendVerificationEmail(user models.User) error {
+	link := configs.RetrieveEnv("APP_URL") + "/verify-email?token=" + url.QueryEscape(user.EmailToken)
+
+	message, err := mailer.Render("verify_email", user.Email, "Verify your email", map[string]string{"FirstName": user.FirstName, "Link": link})
+	if err != nil {
+		return err
+	}
+
+	return configs.Mailer.Send(message)
+}
+
+func SendPasswordResetEmail(user models.User) error {
+	link := configs.RetrieveEnv("APP_URL") + "/reset-password?token=" + url.QueryEscape(user.ResetToken) + "&email=" + url.QueryEscape(user.Email)
+
+	message, err := mailer.Render("reset_password", user.Email, "Reset your password", map[string]string{"FirstName": user.FirstName, "Link": link})
+	if err != nil {
+		return err
+	}
+
+	return configs.Mailer.Send(message)
+}
@@ -0,0 +1,172 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"vehicle-api/valuation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const DefaultAnnualMileage = 12000
+const DefaultForecastYears = 3
+const MaxForecastYears = 10
+
+// the mileage curve is drawn in steps of this many miles, up to a little past the highest mileage of interest
+const mileageCurveStep = 10000
+const maxMileageCurve = 300000
+
+// a valuation request and how far ahead to forecast it
+type ForecastRequest struct {
+	ValuationRequest
+	AnnualMileage int `json:"annual_mileage,omitempty"`
+	Years         int `json:"years,omitempty"`
+}
+
+//AnnualMileage and Years default to DefaultAnnualMileage and DefaultForecastYears when 0
+
+func (request ForecastRequest) Validate() error {
+	if err := request.ValuationRequest.Validate(); err != nil {
+		return err
+	}
+	if request.AnnualMileage < 0 {
+		return errors.New("annual_mileage can't be negative")
+	}
+	if request.Years < 0 || request.Years > MaxForecastYears {
+		return errors.New("years must be between 1 and " + strconv.Itoa(MaxForecastYears))
+	}
+	return nil
+}
+
+type ForecastResult struct {
+	ComparableSet
+	Mileage       int
+	AnnualMileage int
+	Condition     string
+	Estimate      valuation.Estimate
+	Adjustments   []valuation.Adjustment
+	Residuals     []valuation.CurvePoint
+	MileageCurve  []valuation.CurvePoint
+}
+
+//Estimate is today's value, the curves come from the same fitted model
+
+// forecasts the value of the car for each of the next years and values it today at a range of mileages.
+// listings from every year are used whatever the request says, since the forecast is made from older cars
+func RunForecast(ctx context.Context, request ForecastRequest) (ForecastResult, error) {
+	var result ForecastResult
+
+	if request.AnnualMileage == 0 {
+		request.AnnualMileage = DefaultAnnualMileage
+	}
+	if request.Years == 0 {
+		request.Years = DefaultForecastYears
+	}
+	request.MultipleYears = true
+	result.AnnualMileage = request.AnnualMileage
+
+	set, err := FindComparables(ctx, request.ValuationRequest)
+	if err != nil {
+		return result, err
+	}
+	result.ComparableSet = set
+
+	points := make([]valuation.Point, len(set.Comparables))
+	maxMileage := 0.0
+	for i, listing := range set.Comparables {
+		points[i] = valuation.Point{Price: float64(listing.Price), Mileage: float64(listing.Mileage), Year: float64(listing.Year)}
+		maxMileage = math.Max(maxMileage, points[i].Mileage)
+	}
+
+	result.Mileage = request.Mileage
+	if result.Mileage == 0 {
+		result.Mileage = averageMileage(points, set.Spec.Year)
+	}
+
+	current := valuation.Point{Year: float64(set.Spec.Year), Mileage: float64(result.Mileage)}
+	residualTargets := valuation.ResidualTargets(current, float64(request.AnnualMileage), request.Years)
+
+	lastMileage := residualTargets[len(residualTargets)-1].Mileage
+	curveMax := math.Min(maxMileageCurve, math.Ceil(math.Max(maxMileage, lastMileage)/mileageCurveStep)*mileageCurveStep)
+	mileageTargets := valuation.MileageTargets(current.Year, curveMax, mileageCurveStep)
+
+	//one fit for every point so the curves agree with each other and with today's value
+	estimates, err := valuation.ForecastPrices(points, append(append([]valuation.Point{}, residualTargets...), mileageTargets...), request.Model)
+	if err != nil {
+		return result, err
+	}
+
+	adjustments := ValuationAdjustments()
+	result.Condition = strings.ToLower(request.Condition)
+	for i := range estimates {
+		var applied []valuation.Adjustment
+		estimates[i], applied, err = adjustments.Apply(estimates[i], set.Trim, set.TrimMatched, result.Condition, request.Options)
+		if err != nil {
+			return result, err
+		}
+		if i == 0 {
+			result.Adjustments = applied
+		}
+	}
+
+	result.Estimate = estimates[0]
+	result.Residuals = valuation.Curve(points, residualTargets, estimates[:len(residualTargets)], result.Estimate.Price)
+	for k := range result.Residuals {
+		result.Residuals[k].YearsFromNow = k
+	}
+	result.MileageCurve = valuation.Curve(points, mileageTargets, estimates[len(residualTargets):], result.Estimate.Price)
+
+	return result, nil
+}
+
+func roundCurve(curve []valuation.CurvePoint) []valuation.CurvePoint {
+	rounded := make([]valuation.CurvePoint, len(curve))
+	for i, point := range curve {
+		point.Price = roundCents(point.Price)
+		point.Residual = math.Round(point.Residual*10) / 10
+
+		intervals := make([]valuation.Interval, len(point.Intervals))
+		for j, interval := range point.Intervals {
+			intervals[j] = valuation.Interval{Confidence: interval.Confidence, Low: roundCents(interval.Low), High: roundCents(interval.High)}
+		}
+		point.Intervals = intervals
+
+		rounded[i] = point
+	}
+	return rounded
+}
+
+// the response body for a forecast
+func (result ForecastResult) Response() fiber.Map {
+	adjustments := make([]valuation.Adjustment, len(result.Adjustments))
+	for i, adjustment := range result.Adjustments {
+		adjustment.Amount = roundCents(adjustment.Amount)
+		adjustments[i] = adjustment
+	}
+
+	return fiber.Map{
+		"predicted_price":     roundCents(result.Estimate.Price),
+		"residuals":           roundCurve(result.Residuals),
+		"mileage_curve":       roundCurve(result.MileageCurve),
+		"annual_mileage":      result.AnnualMileage,
+		"adjustments":         adjustments,
+		"valuation_model":     result.Estimate.Model,
+		"fallbacks":           result.Estimate.Fallbacks,
+		"r_squared":           result.Estimate.RSquared,
+		"listings_found":      len(result.Listings),
+		"listings_used":       result.Estimate.Observations,
+		"cached":              result.Cached,
+		"listings_fetched_at": result.FetchedAt.UTC().Format(time.RFC3339),
+		"mileage":             strconv.Itoa(result.Mileage),
+		"year":                strconv.Itoa(result.Spec.Year),
+		"make":                result.Spec.Make,
+		"model":               result.Spec.Model,
+		"trim":                result.Trim,
+		"trim_matched":        result.TrimMatched,
+		"condition":           result.Condition,
+	}
+}
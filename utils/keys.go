@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"log"
+	"strings"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var keyCollection *mongo.Collection = configs.GetCollection(configs.DB, "keys")
+
+// api keys look like vk_[8 char public prefix]_[64 char secret]
+// the prefix is stored as is so the key can be found, only a sha256 hash of the secret is stored
+const apiKeyPrefix = "vk_"
+
+func randomHex(length int) (string, error) {
+	b := make([]byte, length/2)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// generates a new api key using crypto/rand, math/rand is not safe for secrets.
+// returns the full key to show the customer once, the public prefix and the hash to store
+func GenerateApiKey() (string, string, string, error) {
+	prefix, err := randomHex(8)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	secret, err := randomHex(64)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	prefix = apiKeyPrefix + prefix
+
+	return prefix + "_" + secret, prefix, HashApiKey(secret), nil
+}
+
+// splits a key into its prefix and secret, ok is false for legacy keys without a prefix
+func ParseApiKey(key string) (string, string, bool) {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return "", "", false
+	}
+
+	separator := strings.LastIndex(key, "_")
+	if separator <= len(apiKeyPrefix) || separator == len(key)-1 {
+		return "", "", false
+	}
+
+	return key[:separator], key[separator+1:], true
+}
+
+func HashApiKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// compares in constant time so response timing doesn't leak how much of the hash matched
+func CompareApiKeyHash(secret string, hash string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashApiKey(secret)), []byte(hash)) == 1
+}
+
+// hashes a legacy plaintext key in place. legacy keys keep working with the same string,
+// they are looked up by the hash of the whole key instead of by prefix
+func MigratePlaintextKey(ctx context.Context, key models.Key) error {
+	_, err := keyCollection.UpdateOne(ctx, bson.M{"_id": key.ID}, bson.M{
+		"$set":   bson.M{"hash": HashApiKey(key.Key)},
+		"$unset": bson.M{"key": ""},
+	})
+	return err
+}
+
+// hashes every key that is still stored in plaintext, safe to run more than once
+func MigratePlaintextKeys() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	cursor, err := keyCollection.Find(ctx, bson.M{"key": bson.M{"$exists": true, "$ne": ""}})
+	if err != nil {
+		log.Println("Error finding plaintext keys:", err)
+		return
+	}
+	defer cursor.Close(ctx)
+
+	migrated := 0
+	for cursor.Next(ctx) {
+		var key models.Key
+		if err := cursor.Decode(&key); err != nil {
+			log.Println("Error decoding key:", err)
+			continue
+		}
+
+		if err := MigratePlaintextKey(ctx, key); err != nil {
+			log.Println("Error migrating key "+key.ID.Hex()+":", err)
+			continue
+		}
+		migrated++
+	}
+
+	if migrated > 0 {
+		log.Println("Hashed", migrated, "plaintext keys")
+	}
+}
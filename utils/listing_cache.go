@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/valuation"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// how long listings are reused when LISTING_CACHE_TTL isn't set
+const defaultListingCacheTTL = 6 * time.Hour
+
+// a listing search as it is kept in redis
+type cachedListings struct {
+	FetchedAt int64               `json:"fetched_at"`
+	Listings  []valuation.Listing `json:"listings"`
+}
+
+// how fresh cached listings have to be. NoCache always fetches, MaxAge of 0 accepts anything still in the cache
+type CacheControl struct {
+	NoCache bool
+	MaxAge  time.Duration
+}
+
+// reads the no-cache and max-age directives of a Cache-Control header, max-age is in seconds
+func ParseCacheControl(header string) CacheControl {
+	var control CacheControl
+	for _, directive := range strings.Split(header, ",") {
+		directive = strings.ToLower(strings.TrimSpace(directive))
+
+		switch {
+		case directive == "no-cache" || directive == "no-store":
+			control.NoCache = true
+		case strings.HasPrefix(directive, "max-age="):
+			seconds, err := strconv.Atoi(strings.TrimPrefix(directive, "max-age="))
+			if err != nil || seconds < 0 {
+				continue
+			}
+			if seconds == 0 {
+				control.NoCache = true
+			}
+			control.MaxAge = time.Duration(seconds) * time.Second
+		}
+	}
+	return control
+}
+
+// listing searches are cached per year, make, model, zip code and radius, e.g. listings:2021:acura:ilx:12345:100
+func listingCacheKey(query valuation.SearchQuery) string {
+	year := strconv.Itoa(query.Year)
+	if query.MultipleYears {
+		year = "all"
+	}
+
+	radius := query.Radius
+	if radius == 0 {
+		radius = valuation.DefaultRadius
+	}
+
+	return "listings:" + year + ":" + strings.ToLower(query.Make) + ":" + strings.ToLower(query.Model) + ":" + query.ZipCode + ":" + strconv.Itoa(radius)
+}
+
+// how long listing searches are cached for, set with LISTING_CACHE_TTL (e.g. 30m, 12h)
+func listingCacheTTL() time.Duration {
+	value := configs.RetrieveEnv("LISTING_CACHE_TTL")
+	if value == "" {
+		return defaultListingCacheTTL
+	}
+
+	ttl, err := time.ParseDuration(value)
+	if err != nil || ttl <= 0 {
+		log.Println("Invalid LISTING_CACHE_TTL, using", defaultListingCacheTTL, err)
+		return defaultListingCacheTTL
+	}
+	return ttl
+}
+
+// returns the listings for a search and when they were fetched, from the cache when it has a fresh enough copy.
+// searches where a source failed aren't cached so the next call tries the source again
+func FetchCachedListings(ctx context.Context, query valuation.SearchQuery, control CacheControl) ([]valuation.Listing, time.Time, bool, error) {
+	key := listingCacheKey(query)
+
+	if !control.NoCache {
+		data, err := configs.Redis.Get(ctx, key).Bytes()
+		if err == nil {
+			var cached cachedListings
+			if err := json.Unmarshal(data, &cached); err == nil {
+				fetchedAt := time.Unix(cached.FetchedAt, 0)
+				if control.MaxAge == 0 || time.Since(fetchedAt) <= control.MaxAge {
+					return cached.Listings, fetchedAt, true, nil
+				}
+			}
+		} else if !errors.Is(err, redis.Nil) {
+			//the cache being down shouldn't stop valuations
+			log.Println("Error reading cached listings:", err)
+		}
+	}
+
+	sources, err := ListingSources()
+	if err != nil {
+		return nil, time.Time{}, false, err
+	}
+
+	fetchedAt := time.Now()
+	listings, err := valuation.FetchListings(ctx, sources, query)
+	if err != nil || len(listings) == 0 {
+		return listings, fetchedAt, false, err
+	}
+
+	if marshalled, err := json.Marshal(cachedListings{FetchedAt: fetchedAt.Unix(), Listings: listings}); err == nil {
+		if err := configs.Redis.Set(ctx, key, marshalled, listingCacheTTL()).Err(); err != nil {
+			log.Println("Error caching listings:", err)
+		}
+	}
+
+	return listings, fetchedAt, false, nil
+}
@@ -2,61 +2,102 @@ package utils
 
 import (
 	"context"
+	"log"
+	"net/url"
+	"strings"
 	"time"
 	"vehicle-api/configs"
 	"vehicle-api/models"
 
-	//"github.com/stripe/stripe-go/v74"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
 var callCollection *mongo.Collection = configs.GetCollection(configs.DB, "calls")
-var userCollection *mongo.Collection = configs.GetCollection(configs.DB, "users")
 
-func LogCall(key models.Key, originalURL string, routeName string) {
+// runs in its own goroutine after the handler has finished, so errors are logged instead of panicking
+func LogCall(key models.Key, originalURL string, routeName string, responseStatus int, responseTime time.Duration) {
 	// first log call in the db
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	// first find the user that called the api
 	newCall := models.Call{
-		User:       key.User,
-		Key:        key.ID,
-		RequestURL: originalURL,
-		CreatedAt:  time.Now().Unix(),
+		User:           key.User,
+		Key:            key.ID,
+		Route:          routeName,
+		RequestURL:     RedactApiKey(originalURL),
+		ResponseStatus: responseStatus,
+		ResponseTime:   int(responseTime.Milliseconds()),
+		CreatedAt:      time.Now().Unix(),
 	}
 
 	_, err := callCollection.InsertOne(ctx, newCall)
 
 	if err != nil {
-		panic(err)
+		log.Println("Error logging call:", err)
+		return
 	}
 
-	// then update the user's call count
-	if routeName != "years" && routeName != "makes" && routeName != "models" && routeName != "trims" {
-		//add usage record for subscription
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-		defer cancel()
-
-		var user models.User
+	//billable calls are reported to stripe in batches by the billing package
+}
 
-		err := userCollection.FindOne(ctx, bson.M{"_id": key.User}).Decode(&user)
+// removes the key query param from a url, the key is a secret and the call already records which key it was
+func RedactApiKey(originalURL string) string {
+	rest, fragment, hasFragment := strings.Cut(originalURL, "#")
+	path, query, hasQuery := strings.Cut(rest, "?")
+	if !hasQuery {
+		return originalURL
+	}
 
-		if err != nil {
-			if err == mongo.ErrNoDocuments {
-				//no user found
-				panic(err)
-			}
+	params := []string{}
+	for _, param := range strings.Split(query, "&") {
+		name, _, _ := strings.Cut(param, "=")
+		if unescaped, err := url.QueryUnescape(name); err == nil && unescaped == "key" {
+			continue
 		}
+		params = append(params, param)
+	}
+
+	redacted := path
+	if len(params) > 0 {
+		redacted += "?" + strings.Join(params, "&")
+	}
+	if hasFragment {
+		redacted += "#" + fragment
+	}
+	return redacted
+}
 
-		/*stripe.Key = configs.RetrieveEnv("STRIPE_SECRET_KEY")
+// removes keys from calls logged before they were redacted
+func RedactLoggedApiKeys() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	cursor, err := callCollection.Find(ctx, bson.M{"request_url": bson.M{"$regex": `[?&]key=`}}, options.Find().SetProjection(bson.M{"request_url": 1}))
+	if err != nil {
+		log.Println("Error finding calls with keys in their url:", err)
+		return
+	}
+	defer cursor.Close(ctx)
 
-		params := &stripe.UsageRecordParams{
-			Quantity: stripe.Int64(1),
-			SubscriptionItem: stripe.String(user.SubscriptionItemID),
-			Timestamp: stripe.Int64(time.Now().Unix()),
+	redacted := 0
+	for cursor.Next(ctx) {
+		var call models.Call
+		if err := cursor.Decode(&call); err != nil {
+			log.Println("Error decoding call:", err)
+			continue
 		}
-		ur, _ := usagerecord.New(params)*/
+
+		if _, err := callCollection.UpdateOne(ctx, bson.M{"_id": call.ID}, bson.M{"$set": bson.M{"request_url": RedactApiKey(call.RequestURL)}}); err != nil {
+			log.Println("Error redacting call "+call.ID.Hex()+":", err)
+			continue
+		}
+		redacted++
+	}
+
+	if redacted > 0 {
+		log.Println("Removed keys from", redacted, "logged calls")
 	}
 }
@@ -0,0 +1,29 @@
+package utils
+
+import (
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const defaultPageSize = 25
+const maxPageSize = 100
+
+// returns the page (starting at 1) and page size from the page and limit query params
+func GetPagination(c *fiber.Ctx) (int64, int64) {
+	page, err := strconv.ParseInt(c.Query("page"), 10, 64)
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	limit, err := strconv.ParseInt(c.Query("limit"), 10, 64)
+	if err != nil || limit < 1 {
+		limit = defaultPageSize
+	}
+
+	if limit > maxPageSize {
+		limit = maxPageSize
+	}
+
+	return page, limit
+}
@@ -0,0 +1,141 @@
+package utils
+
+import (
+	"context"
+	"strconv"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+//redis keys
+/*
+	ratelimit:[key id]:[route]:[unix second] = calls in that second
+	quota:[key id]:[route]:[yyyy-mm] = calls in that month
+*/
+
+type RateLimitResult struct {
+	Allowed        bool
+	QuotaExceeded  bool
+	RetryAfter     int64
+	Limit          int
+	Remaining      int
+	Reset          int64
+	QuotaLimit     int
+	QuotaRemaining int
+	QuotaReset     int64
+}
+
+func startOfNextMonth(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+}
+
+// counts a call against the key's per second and per month limits for the route.
+// a call rejected by the per second limit doesn't count towards the monthly quota
+func CheckRateLimit(ctx context.Context, key models.Key, route string) (RateLimitResult, error) {
+	limit := key.LimitFor(route)
+	now := time.Now().UTC()
+	result := RateLimitResult{Allowed: true, Limit: limit.PerSecond, QuotaLimit: limit.PerMonth}
+
+	if limit.PerSecond > 0 {
+		second := now.Unix()
+		redisKey := "ratelimit:" + key.ID.Hex() + ":" + route + ":" + strconv.FormatInt(second, 10)
+
+		var incr *redis.IntCmd
+		_, err := configs.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			incr = pipe.Incr(ctx, redisKey)
+			pipe.Expire(ctx, redisKey, 2*time.Second)
+			return nil
+		})
+		if err != nil {
+			return result, err
+		}
+
+		count := int(incr.Val())
+		result.Reset = second + 1
+		result.Remaining = maxInt(limit.PerSecond-count, 0)
+
+		if count > limit.PerSecond {
+			result.Allowed = false
+			result.RetryAfter = 1
+			return result, nil
+		}
+	}
+
+	if limit.PerMonth > 0 {
+		if err := countQuota(ctx, key, route, limit, 1, now, &result); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// counts several calls at once against the key's monthly quota for the route, e.g. the rows of a batch.
+// none of them are counted when they don't all fit
+func CheckQuota(ctx context.Context, key models.Key, route string, count int) (RateLimitResult, error) {
+	limit := key.LimitFor(route)
+	result := RateLimitResult{Allowed: true, QuotaLimit: limit.PerMonth}
+
+	if limit.PerMonth == 0 {
+		return result, nil
+	}
+
+	err := countQuota(ctx, key, route, limit, count, time.Now().UTC(), &result)
+	return result, err
+}
+
+// gives back calls counted by CheckQuota that weren't used, e.g. batch rows that failed.
+// they go back to the month they were counted in, reserved is what CheckQuota returned
+func RefundQuota(ctx context.Context, key models.Key, route string, reserved RateLimitResult, count int) error {
+	if count <= 0 || reserved.QuotaLimit == 0 || !reserved.Allowed {
+		return nil
+	}
+
+	month := time.Unix(reserved.QuotaReset, 0).UTC().AddDate(0, -1, 0)
+	return configs.Redis.DecrBy(ctx, quotaKey(key, route, month), int64(count)).Err()
+}
+
+func quotaKey(key models.Key, route string, now time.Time) string {
+	return "quota:" + key.ID.Hex() + ":" + route + ":" + now.Format("2006-01")
+}
+
+func countQuota(ctx context.Context, key models.Key, route string, limit models.Limit, calls int, now time.Time, result *RateLimitResult) error {
+	nextMonth := startOfNextMonth(now)
+	redisKey := quotaKey(key, route, now)
+
+	var incr *redis.IntCmd
+	_, err := configs.Redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.IncrBy(ctx, redisKey, int64(calls))
+		//keep the counter a little past the end of the month for support questions
+		pipe.ExpireAt(ctx, redisKey, nextMonth.AddDate(0, 0, 7))
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	count := int(incr.Val())
+	result.QuotaReset = nextMonth.Unix()
+	result.QuotaRemaining = maxInt(limit.PerMonth-count, 0)
+
+	if count > limit.PerMonth {
+		//rejected calls don't use up quota
+		configs.Redis.DecrBy(ctx, redisKey, int64(calls))
+		result.QuotaRemaining = maxInt(limit.PerMonth-count+calls, 0)
+		result.Allowed = false
+		result.QuotaExceeded = true
+		result.RetryAfter = nextMonth.Unix() - now.Unix()
+	}
+
+	return nil
+}
+
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
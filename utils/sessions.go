@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+	"vehicle-api/configs"
+
+	"github.com/gofiber/fiber/v2"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// a logged in session as shown to the user. the session id itself is never returned, only a hash of it
+type UserSession struct {
+	ID        string `json:"id"`
+	SessionID string `json:"-"`
+	IP        string `json:"ip"`
+	UserAgent string `json:"user_agent"`
+	CreatedAt int64  `json:"created_at"`
+	Current   bool   `json:"current"`
+}
+
+// what is kept in redis, UserSession hides the session id from json so it is stored next to it
+type storedSession struct {
+	UserSession
+	SessionID string `json:"session_id"`
+}
+
+// hash of session ids for each user, used to list and revoke sessions
+func userSessionsKey(userID primitive.ObjectID) string {
+	return "user_sessions:" + userID.Hex()
+}
+
+func publicSessionID(sessionID string) string {
+	sum := sha256.Sum256([]byte(sessionID))
+	return hex.EncodeToString(sum[:16])
+}
+
+// starts a fresh session for the user so a session id set before login can't be reused. only the user id is stored
+func StartUserSession(c *fiber.Ctx, userID primitive.ObjectID) error {
+	store, err := configs.GetSession().Get(c)
+	if err != nil {
+		return err
+	}
+
+	if err := store.Regenerate(); err != nil {
+		return err
+	}
+
+	store.Set("user", userID.Hex())
+
+	if err := store.Save(); err != nil {
+		return err
+	}
+
+	userSession := UserSession{
+		ID:        publicSessionID(store.ID()),
+		SessionID: store.ID(),
+		IP:        c.IP(),
+		UserAgent: string(c.Request().Header.UserAgent()),
+		CreatedAt: time.Now().Unix(),
+	}
+
+	value, err := json.Marshal(storedSession{userSession, userSession.SessionID})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := userSessionsKey(userID)
+	pipe := configs.Redis.TxPipeline()
+	pipe.HSet(ctx, key, userSession.ID, value)
+	pipe.Expire(ctx, key, configs.SessionExpiration)
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+// returns the user's sessions that haven't expired, expired ones are removed from the list
+func ListUserSessions(ctx context.Context, userID primitive.ObjectID, currentSessionID string) ([]UserSession, error) {
+	key := userSessionsKey(userID)
+
+	values, err := configs.Redis.HGetAll(ctx, key).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	userSessions := []UserSession{}
+	for id, value := range values {
+		var stored storedSession
+		if err := json.Unmarshal([]byte(value), &stored); err != nil {
+			configs.Redis.HDel(ctx, key, id)
+			continue
+		}
+
+		exists, err := configs.SessionStorage.Exists(stored.SessionID)
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			configs.Redis.HDel(ctx, key, id)
+			continue
+		}
+
+		userSession := stored.UserSession
+		userSession.SessionID = stored.SessionID
+		userSession.Current = stored.SessionID == currentSessionID
+		userSessions = append(userSessions, userSession)
+	}
+
+	return userSessions, nil
+}
+
+// logs out a single session by its public id, returns false if the user has no such session
+func RevokeUserSession(ctx context.Context, userID primitive.ObjectID, id string) (bool, error) {
+	userSessions, err := ListUserSessions(ctx, userID, "")
+	if err != nil {
+		return false, err
+	}
+
+	for _, userSession := range userSessions {
+		if userSession.ID == id {
+			return true, revoke(ctx, userID, userSession)
+		}
+	}
+
+	return false, nil
+}
+
+// logs out every session of the user except keepSessionID, pass an empty string to log out all of them
+func RevokeUserSessions(ctx context.Context, userID primitive.ObjectID, keepSessionID string) error {
+	userSessions, err := ListUserSessions(ctx, userID, keepSessionID)
+	if err != nil {
+		return err
+	}
+
+	for _, userSession := range userSessions {
+		if userSession.Current {
+			continue
+		}
+		if err := revoke(ctx, userID, userSession); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// removes the session from a user's list when they log out
+func ForgetUserSession(ctx context.Context, userID primitive.ObjectID, sessionID string) error {
+	return configs.Redis.HDel(ctx, userSessionsKey(userID), publicSessionID(sessionID)).Err()
+}
+
+func revoke(ctx context.Context, userID primitive.ObjectID, userSession UserSession) error {
+	if err := configs.SessionStorage.Delete(userSession.SessionID); err != nil {
+		return err
+	}
+
+	return configs.Redis.HDel(ctx, userSessionsKey(userID), userSession.ID).Err()
+}
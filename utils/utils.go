@@ -45,3 +45,8 @@ func GenerateRandomString(length int) string {
 	}
 	return string(b)
 }
+
+// generates a random token for emailed links, GenerateRandomString is not safe for secrets
+func GenerateSecureToken() (string, error) {
+	return randomHex(64)
+}
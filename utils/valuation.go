@@ -0,0 +1,372 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+	"vehicle-api/valuation"
+	"vehicle-api/vin"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var ErrNoListings = errors.New("no listings could be fetched")
+
+// everything needed to value a vehicle, shared by the valuation endpoints
+type ValuationRequest struct {
+	VIN     string `json:"vin"`
+	ZipCode string `json:"zip_code"`
+	Radius  int    `json:"radius,omitempty"`
+	//0 values the car at the average mileage of the listings for its year
+	Mileage          int    `json:"mileage,omitempty"`
+	MultipleYears    bool   `json:"multiple_years,omitempty"`
+	Model            string `json:"model,omitempty"`
+	Outliers         string `json:"outliers,omitempty"`
+	IncludeCertified bool   `json:"include_certified,omitempty"`
+	//defaults to the trim or series decoded from the VIN
+	Trim      string   `json:"trim,omitempty"`
+	Condition string   `json:"condition,omitempty"`
+	Options   []string `json:"options,omitempty"`
+	//MaxAge is in seconds, see CacheControl
+	NoCache bool `json:"no_cache,omitempty"`
+	MaxAge  int  `json:"max_age,omitempty"`
+
+	//a column of a csv row that couldn't be read
+	parseError string
+}
+
+// checks the request before anything is fetched
+func (request ValuationRequest) Validate() error {
+	if request.parseError != "" {
+		return errors.New(request.parseError)
+	}
+	if request.VIN == "" {
+		return errors.New("VIN is required")
+	}
+	if _, err := vin.Parse(request.VIN); err != nil {
+		return err
+	}
+	if request.ZipCode == "" {
+		return errors.New("Zip code is required")
+	}
+	if request.Radius < 0 || request.Mileage < 0 || request.MaxAge < 0 {
+		return errors.New("Radius, mileage and max_age can't be negative")
+	}
+	if request.Model != "" && !valuation.IsModel(request.Model) {
+		return errors.New("model must be one of " + strings.Join(valuation.ModelNames(), ", "))
+	}
+	switch request.Outliers {
+	case "", valuation.OutliersIQR, valuation.OutliersMAD, valuation.OutliersNone:
+	default:
+		return errors.New("outliers must be iqr, mad or none")
+	}
+
+	adjustments := ValuationAdjustments()
+	if request.Condition != "" && !adjustments.IsCondition(request.Condition) {
+		return errors.New("condition must be one of " + strings.Join(adjustments.ConditionNames(), ", "))
+	}
+	for _, option := range request.Options {
+		if !adjustments.IsOption(strings.TrimSpace(option)) {
+			return errors.New("unknown option " + option + ", options must be from " + strings.Join(adjustments.OptionNames(), ", "))
+		}
+	}
+	return nil
+}
+
+func (request ValuationRequest) cleanOptions() valuation.CleanOptions {
+	options := valuation.DefaultCleanOptions()
+	if request.Outliers != "" {
+		options.Outliers = request.Outliers
+	}
+	if request.IncludeCertified {
+		options.ExcludeConditions = []string{valuation.ConditionNew}
+	}
+	return options
+}
+
+// the listings found for a valuation and which of them it is made from
+type ComparableSet struct {
+	Spec        models.VehicleSpec
+	Listings    []valuation.Listing
+	Cached      bool
+	FetchedAt   time.Time
+	Cleaned     valuation.CleanResult
+	Trim        string
+	TrimMatched bool
+	Comparables []valuation.Listing
+}
+
+//Comparables are the cleaned listings with the same trim, or all cleaned listings when too few have it
+
+type ValuationResult struct {
+	ComparableSet
+	Condition   string
+	BasePrice   float64
+	Adjustments []valuation.Adjustment
+	Estimate    valuation.Estimate
+	Mileage     int
+}
+
+//BasePrice is the estimate before trim, condition and option adjustments
+
+var adjustmentsOnce sync.Once
+var adjustments valuation.Adjustments
+
+// the adjustment tables from the JSON file at VALUATION_ADJUSTMENTS, or the built in ones when it isn't set or
+// can't be read
+func ValuationAdjustments() valuation.Adjustments {
+	adjustmentsOnce.Do(func() {
+		adjustments = valuation.DefaultAdjustments()
+
+		path := configs.RetrieveEnv("VALUATION_ADJUSTMENTS")
+		if path == "" {
+			return
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			log.Println("Error opening VALUATION_ADJUSTMENTS, using the default adjustments:", err)
+			return
+		}
+		defer file.Close()
+
+		loaded, err := valuation.LoadAdjustments(file)
+		if err != nil {
+			log.Println("Invalid VALUATION_ADJUSTMENTS, using the default adjustments:", err)
+			return
+		}
+		adjustments = loaded
+	})
+	return adjustments
+}
+
+// fetches the listings for a search, see FetchCachedListings
+type listingFetcher func(ctx context.Context, query valuation.SearchQuery, control CacheControl) ([]valuation.Listing, time.Time, bool, error)
+
+// decodes the VIN, fetches listings from every configured source, cleans them and fits the requested model
+func RunValuation(ctx context.Context, request ValuationRequest) (ValuationResult, error) {
+	return runValuation(ctx, request, FetchCachedListings)
+}
+
+// decodes the VIN, fetches listings from every configured source, cleans them and picks the ones with the same trim
+func FindComparables(ctx context.Context, request ValuationRequest) (ComparableSet, error) {
+	return findComparables(ctx, request, FetchCachedListings)
+}
+
+func findComparables(ctx context.Context, request ValuationRequest, fetchListings listingFetcher) (ComparableSet, error) {
+	var set ComparableSet
+
+	spec, err := DecodeVin(ctx, request.VIN)
+	if err != nil {
+		return set, err
+	}
+	set.Spec = spec
+
+	query := valuation.SearchQuery{
+		Year:          spec.Year,
+		Make:          spec.Make,
+		Model:         spec.Model,
+		ZipCode:       request.ZipCode,
+		Radius:        request.Radius,
+		MultipleYears: request.MultipleYears,
+	}
+	control := CacheControl{NoCache: request.NoCache, MaxAge: time.Duration(request.MaxAge) * time.Second}
+
+	listings, fetchedAt, cached, err := fetchListings(ctx, query, control)
+	if err != nil {
+		//a failing source only matters if nothing else found listings
+		log.Println("Error fetching listings:", err)
+		if len(listings) == 0 {
+			//report running out of time rather than a lack of listings
+			if ctx.Err() != nil {
+				return set, ctx.Err()
+			}
+			return set, ErrNoListings
+		}
+	}
+	set.Listings = listings
+	set.Cached = cached
+	set.FetchedAt = fetchedAt
+
+	//drop new, certified, salvage and implausible listings before fitting
+	set.Cleaned = valuation.Clean(listings, time.Now().Year(), request.cleanOptions())
+
+	set.Trim = request.Trim
+	if set.Trim == "" {
+		set.Trim = spec.Trim
+	}
+	if set.Trim == "" {
+		set.Trim = spec.Series
+	}
+
+	//value from listings with the same trim when there are enough of them, otherwise the trim table adjusts for it
+	set.Comparables, set.TrimMatched = ValuationAdjustments().MatchTrim(set.Cleaned.Kept, set.Trim)
+
+	return set, nil
+}
+
+// every listing found, flagged with whether it was left out of the valuation and why
+func (set ComparableSet) All() []valuation.Comparable {
+	all := make([]valuation.Comparable, 0, len(set.Listings))
+
+	for _, listing := range set.Comparables {
+		all = append(all, valuation.Comparable{Listing: listing})
+	}
+
+	//kept listings that aren't comparables were left out for their trim
+	index := 0
+	for _, listing := range set.Cleaned.Kept {
+		if index < len(set.Comparables) && set.Comparables[index] == listing {
+			index++
+			continue
+		}
+		all = append(all, valuation.Comparable{Listing: listing, Excluded: true, Reason: valuation.DroppedTrim})
+	}
+
+	for _, excluded := range set.Cleaned.Excluded {
+		all = append(all, valuation.Comparable{Listing: excluded.Listing, Excluded: true, Reason: excluded.Reason})
+	}
+
+	return all
+}
+
+func runValuation(ctx context.Context, request ValuationRequest, fetchListings listingFetcher) (ValuationResult, error) {
+	var result ValuationResult
+
+	set, err := findComparables(ctx, request, fetchListings)
+	if err != nil {
+		return result, err
+	}
+	result.ComparableSet = set
+	spec := set.Spec
+	adjustments := ValuationAdjustments()
+
+	points := make([]valuation.Point, len(set.Comparables))
+	for i, listing := range set.Comparables {
+		points[i] = valuation.Point{Price: float64(listing.Price), Mileage: float64(listing.Mileage), Year: float64(listing.Year)}
+	}
+
+	result.Mileage = request.Mileage
+	if result.Mileage == 0 {
+		result.Mileage = averageMileage(points, spec.Year)
+	}
+
+	estimate, err := valuation.EstimatePrice(points, valuation.Point{Mileage: float64(result.Mileage), Year: float64(spec.Year)}, request.Model)
+	if err != nil {
+		return result, err
+	}
+	result.BasePrice = estimate.Price
+
+	result.Condition = strings.ToLower(request.Condition)
+	result.Estimate, result.Adjustments, err = adjustments.Apply(estimate, result.Trim, result.TrimMatched, result.Condition, request.Options)
+	if err != nil {
+		return result, err
+	}
+
+	return result, nil
+}
+
+// average mileage of the listings from the same year, or of all listings when there are none
+func averageMileage(points []valuation.Point, year int) int {
+	total, count := 0.0, 0
+	for _, point := range points {
+		if int(point.Year) == year {
+			total += point.Mileage
+			count++
+		}
+	}
+
+	if count == 0 {
+		for _, point := range points {
+			total += point.Mileage
+		}
+		count = len(points)
+	}
+
+	if count == 0 {
+		return 0
+	}
+	return int(total) / count
+}
+
+// sources listed in LISTING_SOURCES (comma separated, in order of preference), defaults to autotrader
+func ListingSources() ([]valuation.ListingSource, error) {
+	names := configs.RetrieveEnv("LISTING_SOURCES")
+	if names == "" {
+		names = "autotrader"
+	}
+	return valuation.Sources(strings.Split(names, ","))
+}
+
+// true when the valuation failed because of the listings rather than something on our side
+func IsNotEnoughListings(err error) bool {
+	return errors.Is(err, valuation.ErrNotEnoughListings) || errors.Is(err, valuation.ErrSingular)
+}
+
+// the status and message to respond with when a valuation fails
+func ValuationErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrVinNotFound):
+		return http.StatusNotFound, "No results found for the VIN."
+	case errors.Is(err, ErrNoListings) || IsNotEnoughListings(err):
+		return http.StatusUnprocessableEntity, "Not enough results. Please expand the search radius and try querying with multiple_years=true"
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "The valuation took too long. Please try again or create a valuation job."
+	}
+	return http.StatusInternalServerError, "Something went wrong. Please try again later."
+}
+
+func roundCents(value float64) float64 {
+	return math.Floor(value*100) / 100
+}
+
+// the response body for a valuation
+func (result ValuationResult) Response() fiber.Map {
+	estimate := result.Estimate
+
+	intervals := make([]valuation.Interval, len(estimate.Intervals))
+	for i, interval := range estimate.Intervals {
+		intervals[i] = valuation.Interval{Confidence: interval.Confidence, Low: roundCents(interval.Low), High: roundCents(interval.High)}
+	}
+
+	adjustments := make([]valuation.Adjustment, len(result.Adjustments))
+	for i, adjustment := range result.Adjustments {
+		adjustment.Amount = roundCents(adjustment.Amount)
+		adjustments[i] = adjustment
+	}
+
+	return fiber.Map{
+		"predicted_price":         roundCents(estimate.Price),
+		"base_price":              roundCents(result.BasePrice),
+		"adjustments":             adjustments,
+		"trim":                    result.Trim,
+		"trim_matched":            result.TrimMatched,
+		"condition":               result.Condition,
+		"prediction_intervals":    intervals,
+		"valuation_model":         estimate.Model,
+		"clamped":                 estimate.Clamped,
+		"fallbacks":               estimate.Fallbacks,
+		"r_squared":               estimate.RSquared,
+		"residual_standard_error": roundCents(estimate.ResidualStdError),
+		"coefficients":            estimate.Coefficients,
+		"listings_found":          len(result.Listings),
+		"cached":                  result.Cached,
+		"listings_fetched_at":     result.FetchedAt.UTC().Format(time.RFC3339),
+		"listings_used":           estimate.Observations,
+		"listings_dropped":        result.Cleaned.Dropped,
+		"based_on":                strconv.Itoa(estimate.Observations) + " results",
+		"mileage":                 strconv.Itoa(result.Mileage),
+		"year":                    strconv.Itoa(result.Spec.Year),
+		"make":                    result.Spec.Make,
+		"model":                   result.Spec.Model,
+	}
+}
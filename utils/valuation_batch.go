@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+	"vehicle-api/batch"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+	"vehicle-api/valuation"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// most rows one batch can have, bigger portfolios are split over several batches
+const MaxBatchRows = 1000
+
+const defaultBatchConcurrency = 8
+
+// rows that haven't started by then are failed rather than holding the request open
+const batchTimeout = 10 * time.Minute
+
+// the outcome of one row of a batch
+type BatchRowResult struct {
+	Row     int              `json:"row"`
+	Request ValuationRequest `json:"request"`
+	Status  int              `json:"status"`
+	Result  *ValuationResult `json:"-"`
+	Error   string           `json:"error,omitempty"`
+}
+
+//Row counts from 1, for csv uploads it is the line number not counting the header
+
+// reads batch rows from a csv with a header row, see batch.Columns. rows with a column that can't be read
+// are returned anyway and fail validation with the column's error
+func ParseValuationCSV(r io.Reader) ([]ValuationRequest, error) {
+	rows, err := batch.ParseCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	requests := make([]ValuationRequest, len(rows))
+	for i, row := range rows {
+		requests[i] = ValuationRequest{
+			VIN:              row.VIN,
+			ZipCode:          row.ZipCode,
+			Mileage:          row.Mileage,
+			Radius:           row.Radius,
+			MultipleYears:    row.MultipleYears,
+			Model:            row.Model,
+			Outliers:         row.Outliers,
+			IncludeCertified: row.IncludeCertified,
+			Trim:             row.Trim,
+			Condition:        row.Condition,
+			Options:          row.Options,
+			parseError:       row.Error,
+		}
+	}
+
+	return requests, nil
+}
+
+// values every row with at most VALUATION_BATCH_CONCURRENCY (default 8) running at once. rows searching for
+// the same listings share one fetch, so a fleet of one model in one zip code is only scraped once
+func RunValuationBatch(ctx context.Context, requests []ValuationRequest, control CacheControl) []BatchRowResult {
+	concurrency := defaultBatchConcurrency
+	if value := configs.RetrieveEnv("VALUATION_BATCH_CONCURRENCY"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			log.Println("Invalid VALUATION_BATCH_CONCURRENCY, using", defaultBatchConcurrency, err)
+		} else {
+			concurrency = parsed
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, batchTimeout)
+	defer cancel()
+
+	fetch := sharedListingFetcher()
+	results := make([]BatchRowResult, len(requests))
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, request := range requests {
+		//the cache control of the batch applies to every row
+		request.NoCache = control.NoCache
+		request.MaxAge = int(control.MaxAge / time.Second)
+		results[i] = BatchRowResult{Row: i + 1, Request: request}
+
+		if err := request.Validate(); err != nil {
+			results[i].Status = http.StatusBadRequest
+			results[i].Error = err.Error()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, request ValuationRequest) {
+			defer wg.Done()
+
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				results[i].Status, results[i].Error = ValuationErrorStatus(ctx.Err())
+				return
+			}
+
+			rowCtx, rowCancel := context.WithTimeout(ctx, SyncValuationTimeout)
+			defer rowCancel()
+
+			result, err := runValuation(rowCtx, request, fetch)
+			if err != nil {
+				results[i].Status, results[i].Error = ValuationErrorStatus(err)
+				if results[i].Status == http.StatusInternalServerError {
+					log.Println("Error valuing batch row "+strconv.Itoa(i+1)+":", err)
+				}
+				return
+			}
+
+			results[i].Status = http.StatusOK
+			results[i].Result = &result
+		}(i, request)
+	}
+
+	wg.Wait()
+	return results
+}
+
+// a listing search shared by the rows of a batch
+type sharedFetch struct {
+	once      sync.Once
+	listings  []valuation.Listing
+	fetchedAt time.Time
+	cached    bool
+	err       error
+}
+
+// fetches each listing search once for the whole batch, rows asking for a search that is already being
+// fetched wait for it instead of scraping it again
+func sharedListingFetcher() listingFetcher {
+	var mutex sync.Mutex
+	fetches := map[string]*sharedFetch{}
+
+	return func(ctx context.Context, query valuation.SearchQuery, control CacheControl) ([]valuation.Listing, time.Time, bool, error) {
+		key := listingCacheKey(query)
+
+		mutex.Lock()
+		fetch, ok := fetches[key]
+		if !ok {
+			fetch = &sharedFetch{}
+			fetches[key] = fetch
+		}
+		mutex.Unlock()
+
+		fetch.once.Do(func() {
+			fetch.listings, fetch.fetchedAt, fetch.cached, fetch.err = FetchCachedListings(ctx, query, control)
+		})
+
+		return fetch.listings, fetch.fetchedAt, fetch.cached, fetch.err
+	}
+}
+
+// logs a call for every row that was valued so the batch is billed per row. RapidAPI batches have no key
+func LogBatchCalls(key *models.Key, originalURL string, results []BatchRowResult, responseTime time.Duration) {
+	if key == nil {
+		return
+	}
+
+	for _, row := range results {
+		LogCall(*key, originalURL+"#row="+strconv.Itoa(row.Row), "valuation", row.Status, responseTime)
+	}
+}
+
+// the response body for a row
+func (row BatchRowResult) Response() fiber.Map {
+	response := fiber.Map{
+		"row":      row.Row,
+		"vin":      row.Request.VIN,
+		"zip_code": row.Request.ZipCode,
+		"status":   row.Status,
+	}
+
+	if row.Result != nil {
+		response["result"] = row.Result.Response()
+	} else {
+		response["error"] = row.Error
+	}
+
+	return response
+}
+
+// writes the results as csv, one line per row in the order they were sent
+func WriteValuationCSV(w io.Writer, results []BatchRowResult) error {
+	rows := make([]batch.Result, len(results))
+	for i, row := range results {
+		rows[i] = batch.Result{
+			Row:     row.Row,
+			VIN:     row.Request.VIN,
+			ZipCode: row.Request.ZipCode,
+			Mileage: row.Request.Mileage,
+			Status:  row.Status,
+			Error:   row.Error,
+		}
+
+		if result := row.Result; result != nil {
+			rows[i].Mileage = result.Mileage
+			rows[i].Valuation = &batch.Valuation{
+				Year:         result.Spec.Year,
+				Make:         result.Spec.Make,
+				Model:        result.Spec.Model,
+				Trim:         result.Trim,
+				Price:        result.Estimate.Price,
+				Intervals:    result.Estimate.Intervals,
+				ModelName:    result.Estimate.Model,
+				ListingsUsed: result.Estimate.Observations,
+				Cached:       result.Cached,
+				FetchedAt:    result.FetchedAt,
+			}
+		}
+	}
+
+	return batch.WriteCSV(w, rows)
+}
@@ -0,0 +1,448 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/jobs"
+	"vehicle-api/models"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+//redis keys
+/*
+	valuation_job:[job id] = the job as json, kept for a day
+	valuation_jobs:queue = ids of jobs waiting for a worker
+	valuation_jobs:processing = ids of jobs a worker has taken, requeued if the worker dies
+	valuation_jobs:claims = hash of job id to when a worker took it off the queue
+*/
+
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+const (
+	WebhookDelivered = "delivered"
+	WebhookFailed    = "failed"
+)
+
+// how long a synchronous valuation can take before it is abandoned
+const SyncValuationTimeout = 25 * time.Second
+
+// jobs can wait longer on slow sources but not forever
+const jobValuationTimeout = 2 * time.Minute
+
+const jobExpiration = 24 * time.Hour
+const maxJobAttempts = 3
+const defaultValuationWorkers = 4
+
+const jobQueueKey = "valuation_jobs:queue"
+const jobProcessingKey = "valuation_jobs:processing"
+const jobClaimsKey = "valuation_jobs:claims"
+
+var ErrJobNotFound = jobs.ErrNotFound
+
+// a valuation run in the background by a worker
+type ValuationJob struct {
+	ID            string           `json:"id"`
+	Status        string           `json:"status"`
+	Request       ValuationRequest `json:"request"`
+	CallbackURL   string           `json:"callback_url,omitempty"`
+	SigningSecret string           `json:"signing_secret,omitempty"`
+	Result        fiber.Map        `json:"result,omitempty"`
+	Error         string           `json:"error,omitempty"`
+	ErrorStatus   int              `json:"error_status,omitempty"`
+	WebhookStatus string           `json:"webhook_status,omitempty"`
+	KeyID         string           `json:"key_id,omitempty"`
+	UserID        string           `json:"user_id,omitempty"`
+	Attempts      int              `json:"attempts"`
+	CreatedAt     int64            `json:"created_at"`
+	StartedAt     int64            `json:"started_at,omitempty"`
+	CompletedAt   int64            `json:"completed_at,omitempty"`
+}
+
+//KeyID and UserID are empty for jobs created through RapidAPI
+//SigningSecret signs the webhook, it is only shown to the customer when the job is created
+
+func valuationJobKey(id string) string {
+	return "valuation_job:" + id
+}
+
+// the job as it is shown to the customer
+func (job ValuationJob) Response() fiber.Map {
+	response := fiber.Map{
+		"id":         job.ID,
+		"status":     job.Status,
+		"request":    job.Request,
+		"created_at": job.CreatedAt,
+	}
+
+	if job.CallbackURL != "" {
+		response["callback_url"] = job.CallbackURL
+		response["webhook_status"] = job.WebhookStatus
+	}
+	if job.StartedAt != 0 {
+		response["started_at"] = job.StartedAt
+	}
+	if job.CompletedAt != 0 {
+		response["completed_at"] = job.CompletedAt
+	}
+	if job.Status == JobSucceeded {
+		response["result"] = job.Result
+	}
+	if job.Status == JobFailed {
+		response["error"] = job.Error
+		response["error_status"] = job.ErrorStatus
+	}
+
+	return response
+}
+
+// only the user that created a job can see it, RapidAPI jobs can only be seen through RapidAPI
+func (job ValuationJob) OwnedBy(userID string) bool {
+	return job.UserID == userID
+}
+
+// checks a webhook url before a job is queued, the address it resolves to is checked again when it is called
+func ValidateCallbackURL(callbackURL string) error {
+	parsed, err := url.Parse(callbackURL)
+	if err != nil || parsed.Host == "" || (parsed.Scheme != "https" && parsed.Scheme != "http") {
+		return errors.New("callback_url must be an absolute http or https url")
+	}
+	return nil
+}
+
+// queues a valuation. key is nil for RapidAPI calls
+func CreateValuationJob(ctx context.Context, request ValuationRequest, callbackURL string, key *models.Key) (ValuationJob, error) {
+	id, err := randomHex(32)
+	if err != nil {
+		return ValuationJob{}, err
+	}
+
+	job := ValuationJob{
+		ID:          id,
+		Status:      JobQueued,
+		Request:     request,
+		CallbackURL: callbackURL,
+		CreatedAt:   time.Now().Unix(),
+	}
+
+	if key != nil {
+		job.KeyID = key.ID.Hex()
+		job.UserID = key.User.Hex()
+	}
+
+	if callbackURL != "" {
+		secret, err := randomHex(64)
+		if err != nil {
+			return job, err
+		}
+		job.SigningSecret = "whsec_" + secret
+	}
+
+	if err := saveValuationJob(ctx, job); err != nil {
+		return job, err
+	}
+
+	if err := configs.Redis.RPush(ctx, jobQueueKey, job.ID).Err(); err != nil {
+		configs.Redis.Del(ctx, valuationJobKey(job.ID))
+		return job, err
+	}
+
+	return job, nil
+}
+
+func GetValuationJob(ctx context.Context, id string) (ValuationJob, error) {
+	var job ValuationJob
+
+	data, err := configs.Redis.Get(ctx, valuationJobKey(id)).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return job, ErrJobNotFound
+	}
+	if err != nil {
+		return job, err
+	}
+
+	err = json.Unmarshal(data, &job)
+	return job, err
+}
+
+func saveValuationJob(ctx context.Context, job ValuationJob) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	return configs.Redis.Set(ctx, valuationJobKey(job.ID), data, jobExpiration).Err()
+}
+
+// starts VALUATION_WORKERS (default 4) workers taking jobs off the queue, and requeues jobs whose worker died
+func StartValuationWorkers() {
+	workers := defaultValuationWorkers
+	if value := configs.RetrieveEnv("VALUATION_WORKERS"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			log.Println("Invalid VALUATION_WORKERS, using", defaultValuationWorkers, err)
+		} else {
+			workers = parsed
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		go valuationWorker()
+	}
+
+	if workers > 0 {
+		go func() {
+			ticker := time.NewTicker(time.Minute)
+			defer ticker.Stop()
+
+			for range ticker.C {
+				requeueStalledJobs()
+			}
+		}()
+	}
+}
+
+func valuationWorker() {
+	for {
+		//the id is moved to the processing list so it isn't lost if this instance dies mid job
+		id, err := configs.Redis.BLMove(context.Background(), jobQueueKey, jobProcessingKey, "LEFT", "RIGHT", 5*time.Second).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			log.Println("Error taking a valuation job off the queue:", err)
+			time.Sleep(time.Second)
+			continue
+		}
+
+		//a requeuer that sees the job before this lands stamps it itself
+		if err := configs.Redis.HSet(context.Background(), jobClaimsKey, id, time.Now().Unix()).Err(); err != nil {
+			log.Println("Error claiming valuation job "+id+":", err)
+		}
+
+		started := runValuationJob(id)
+
+		released, err := (redisJobStore{}).Release(context.Background(), id)
+		if err != nil {
+			log.Println("Error removing valuation job "+id+" from processing:", err)
+		}
+
+		//a job that couldn't be started goes back on the queue rather than waiting out its expiry, unless
+		//another instance already requeued it
+		if !started && released {
+			if err := configs.Redis.RPush(context.Background(), jobQueueKey, id).Err(); err != nil {
+				log.Println("Error requeueing valuation job "+id+":", err)
+			}
+			time.Sleep(time.Second)
+		}
+	}
+}
+
+// runs a job taken off the queue, false if it couldn't be loaded or marked running and should be tried again
+func runValuationJob(id string) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), jobValuationTimeout)
+	defer cancel()
+
+	job, err := GetValuationJob(ctx, id)
+	if err != nil {
+		//expired jobs have nothing left to do
+		if err == ErrJobNotFound {
+			return true
+		}
+		log.Println("Error loading valuation job "+id+":", err)
+		return false
+	}
+
+	start := time.Now()
+	job.Status = JobRunning
+	job.StartedAt = start.Unix()
+	job.Attempts++
+	if err := saveValuationJob(ctx, job); err != nil {
+		log.Println("Error starting valuation job "+id+":", err)
+		return false
+	}
+
+	result, err := RunValuation(ctx, job.Request)
+
+	status := http.StatusOK
+	if err != nil {
+		var message string
+		status, message = ValuationErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.Println("Error running valuation job "+id+":", err)
+		}
+
+		job.Status = JobFailed
+		job.Error = message
+		job.ErrorStatus = status
+	} else {
+		job.Status = JobSucceeded
+		job.Result = result.Response()
+	}
+	job.CompletedAt = time.Now().Unix()
+
+	//the job may have outlived the valuation timeout, saving it gets its own
+	saveCtx, saveCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer saveCancel()
+
+	if err := saveValuationJob(saveCtx, job); err != nil {
+		log.Println("Error saving valuation job "+id+":", err)
+		return true
+	}
+
+	//the job is billed like a synchronous valuation once it has a result, creating and polling it isn't
+	if job.KeyID != "" {
+		keyID, _ := primitive.ObjectIDFromHex(job.KeyID)
+		userID, _ := primitive.ObjectIDFromHex(job.UserID)
+		go LogCall(models.Key{ID: keyID, User: userID}, "/api/v1/valuation/jobs/"+job.ID, "valuation", status, time.Since(start))
+	}
+
+	if job.CallbackURL != "" {
+		go deliverJobWebhook(job)
+	}
+	return true
+}
+
+// the valuation job queue in redis
+type redisJobStore struct{}
+
+func (redisJobStore) Processing(ctx context.Context) ([]string, error) {
+	return configs.Redis.LRange(ctx, jobProcessingKey, 0, -1).Result()
+}
+
+func (redisJobStore) Claimed(ctx context.Context, id string, now int64) (int64, error) {
+	if err := configs.Redis.HSetNX(ctx, jobClaimsKey, id, now).Err(); err != nil {
+		return 0, err
+	}
+	return configs.Redis.HGet(ctx, jobClaimsKey, id).Int64()
+}
+
+func (redisJobStore) Attempts(ctx context.Context, id string) (int, error) {
+	job, err := GetValuationJob(ctx, id)
+	return job.Attempts, err
+}
+
+func (redisJobStore) Release(ctx context.Context, id string) (bool, error) {
+	removed, err := configs.Redis.LRem(ctx, jobProcessingKey, 1, id).Result()
+	if err != nil || removed == 0 {
+		return false, err
+	}
+
+	//a claim left behind is overwritten when the job is taken again
+	if err := configs.Redis.HDel(ctx, jobClaimsKey, id).Err(); err != nil {
+		log.Println("Error removing the claim on valuation job "+id+":", err)
+	}
+	return true, nil
+}
+
+func (redisJobStore) Fail(ctx context.Context, id string) error {
+	job, err := GetValuationJob(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	job.Status = JobFailed
+	job.Error = "The valuation could not be completed. Please try again later."
+	job.ErrorStatus = http.StatusInternalServerError
+	job.CompletedAt = time.Now().Unix()
+	return saveValuationJob(ctx, job)
+}
+
+func (redisJobStore) Requeue(ctx context.Context, id string) error {
+	job, err := GetValuationJob(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	job.Status = JobQueued
+	job.StartedAt = 0
+	if err := saveValuationJob(ctx, job); err != nil {
+		return err
+	}
+	return configs.Redis.RPush(ctx, jobQueueKey, id).Err()
+}
+
+// puts jobs back on the queue when their worker has had them for longer than a job can take, including jobs
+// it died before starting. jobs that keep stalling are failed
+func requeueStalledJobs() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	requeued, failed, err := jobs.RequeueStalled(ctx, redisJobStore{}, time.Now(), jobValuationTimeout+time.Minute, maxJobAttempts)
+	if err != nil {
+		log.Println("Error listing valuation jobs in processing:", err)
+		return
+	}
+	if requeued > 0 || failed > 0 {
+		log.Println("Requeued", requeued, "stalled valuation jobs and failed", failed)
+	}
+}
+
+// posts the finished job to its callback url, retrying with backoff until it gets a 2xx
+func deliverJobWebhook(job ValuationJob) {
+	body, err := json.Marshal(job.Response())
+	if err != nil {
+		log.Println("Error encoding webhook for valuation job "+job.ID+":", err)
+		return
+	}
+
+	job.WebhookStatus = WebhookFailed
+	backoff := 2 * time.Second
+
+	for attempt := 1; attempt <= maxJobAttempts; attempt++ {
+		err = postWebhook(job, body)
+		if err == nil {
+			job.WebhookStatus = WebhookDelivered
+			break
+		}
+
+		log.Println("Error delivering webhook for valuation job "+job.ID+" (attempt "+strconv.Itoa(attempt)+"):", err)
+		if attempt < maxJobAttempts {
+			time.Sleep(backoff)
+			backoff *= 5
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if err := saveValuationJob(ctx, job); err != nil {
+		log.Println("Error saving webhook status for valuation job "+job.ID+":", err)
+	}
+}
+
+func postWebhook(job ValuationJob, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, job.CallbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Valuation-Signature", jobs.SignWebhook(job.SigningSecret, time.Now().Unix(), body))
+	req.Header.Set("X-Valuation-Job", job.ID)
+
+	response, err := jobs.WebhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < 200 || response.StatusCode >= 300 {
+		return fmt.Errorf("callback returned status %d", response.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,272 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const vpicBaseURL = "https://vpic.nhtsa.dot.gov/api/vehicles"
+
+// decoded VINs don't change, so they are kept until redis evicts them. changes to the decoder are picked up
+// through vinDecoderVersion
+const vinCacheExpiration = 0
+
+// bump when a change to the decoder gives different specs for the same VIN, old entries are then ignored until
+// redis evicts them. 2 decodes the model year of imports from position 7
+const vinDecoderVersion = 2
+
+var ErrVinNotFound = errors.New("no results found for the VIN")
+
+var vpicClient = &http.Client{Timeout: 15 * time.Second}
+
+// the fields we use from vPIC's DecodeVinValues response, every value is a string
+type vpicValues struct {
+	ModelYear           string `json:"ModelYear"`
+	Make                string `json:"Make"`
+	Model               string `json:"Model"`
+	Series              string `json:"Series"`
+	Trim                string `json:"Trim"`
+	Manufacturer        string `json:"Manufacturer"`
+	VehicleType         string `json:"VehicleType"`
+	BodyClass           string `json:"BodyClass"`
+	Doors               string `json:"Doors"`
+	DriveType           string `json:"DriveType"`
+	FuelTypePrimary     string `json:"FuelTypePrimary"`
+	TransmissionStyle   string `json:"TransmissionStyle"`
+	EngineCylinders     string `json:"EngineCylinders"`
+	DisplacementL       string `json:"DisplacementL"`
+	DisplacementCC      string `json:"DisplacementCC"`
+	EngineHP            string `json:"EngineHP"`
+	EngineConfiguration string `json:"EngineConfiguration"`
+	EngineModel         string `json:"EngineModel"`
+	PlantCountry        string `json:"PlantCountry"`
+	PlantState          string `json:"PlantState"`
+	PlantCity           string `json:"PlantCity"`
+	GVWR                string `json:"GVWR"`
+	ErrorCode           string `json:"ErrorCode"`
+	ErrorText           string `json:"ErrorText"`
+}
+
+func vinCacheKey(vin string) string {
+	return "vin:v" + strconv.Itoa(vinDecoderVersion) + ":" + vin
+}
+
+// deletes VINs cached by an older decoder, they never expire so they'd otherwise stay until redis evicts them
+func RemoveStaleVinCache() {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Minute)
+	defer cancel()
+
+	removed := 0
+	iter := configs.Redis.Scan(ctx, 0, "vin:*", 1000).Iterator()
+	for iter.Next(ctx) {
+		if strings.HasPrefix(iter.Val(), vinCacheKey("")) {
+			continue
+		}
+		if err := configs.Redis.Del(ctx, iter.Val()).Err(); err != nil {
+			log.Println("Error removing cached VIN:", err)
+			continue
+		}
+		removed++
+	}
+	if err := iter.Err(); err != nil {
+		log.Println("Error scanning cached VINs:", err)
+	}
+
+	if removed > 0 {
+		log.Println("Removed", removed, "VINs cached by an older decoder")
+	}
+}
+
+// returns the specs for a VIN, decoded VINs are cached in redis. how VINs are decoded is set with VIN_DECODER:
+// local (default) uses the imported vPIC patterns and falls back to the vPIC api when they don't cover the VIN,
+// remote only uses the vPIC api and diff decodes with both, logs any differences and prefers the local result
+func DecodeVin(ctx context.Context, vin string) (models.VehicleSpec, error) {
+	vin = strings.ToUpper(strings.TrimSpace(vin))
+	mode := configs.RetrieveEnv("VIN_DECODER")
+
+	var spec models.VehicleSpec
+
+	//diff mode skips the cache so every decode is compared
+	if mode != "diff" {
+		cached, err := configs.Redis.Get(ctx, vinCacheKey(vin)).Bytes()
+		if err == nil {
+			if err := json.Unmarshal(cached, &spec); err == nil {
+				return spec, nil
+			}
+		} else if !errors.Is(err, redis.Nil) {
+			//the cache being down shouldn't stop decoding
+			log.Println("Error reading cached VIN:", err)
+		}
+	}
+
+	spec, err := decodeVin(ctx, vin, mode)
+	if err != nil {
+		return spec, err
+	}
+
+	//a failed cache write only costs another decode
+	if marshalled, err := json.Marshal(spec); err == nil {
+		configs.Redis.Set(ctx, vinCacheKey(vin), marshalled, vinCacheExpiration)
+	}
+
+	return spec, nil
+}
+
+func decodeVin(ctx context.Context, vin string, mode string) (models.VehicleSpec, error) {
+	if mode == "remote" {
+		return DecodeVinRemote(ctx, vin)
+	}
+
+	local, localErr := DecodeVinLocal(ctx, vin)
+	if localErr != nil && localErr != ErrVinNotFound {
+		log.Println("Error decoding VIN "+vin+" locally, falling back to vPIC:", localErr)
+	}
+
+	if mode == "diff" {
+		remote, remoteErr := DecodeVinRemote(ctx, vin)
+
+		switch {
+		case localErr != nil && remoteErr == nil:
+			log.Println("VIN diff " + vin + ": only vPIC could decode it")
+		case localErr == nil && remoteErr != nil:
+			log.Println("VIN diff "+vin+": only the local patterns could decode it, vPIC:", remoteErr)
+		case localErr == nil && remoteErr == nil:
+			for _, diff := range DiffVehicleSpecs(local, remote) {
+				log.Println("VIN diff " + vin + ": " + diff)
+			}
+		}
+
+		if localErr == nil {
+			return local, nil
+		}
+		return remote, remoteErr
+	}
+
+	if localErr == nil {
+		return local, nil
+	}
+
+	return DecodeVinRemote(ctx, vin)
+}
+
+// lists the fields that differ between a local and a remote decode as "field: local x, remote y"
+func DiffVehicleSpecs(local models.VehicleSpec, remote models.VehicleSpec) []string {
+	diffs := []string{}
+	diffFields("", reflect.ValueOf(local), reflect.ValueOf(remote), &diffs)
+	return diffs
+}
+
+func diffFields(prefix string, local reflect.Value, remote reflect.Value, diffs *[]string) {
+	for i := 0; i < local.NumField(); i++ {
+		name := prefix + strings.Split(local.Type().Field(i).Tag.Get("json"), ",")[0]
+
+		if local.Field(i).Kind() == reflect.Struct {
+			diffFields(name+".", local.Field(i), remote.Field(i), diffs)
+			continue
+		}
+
+		if local.Field(i).Interface() != remote.Field(i).Interface() {
+			*diffs = append(*diffs, fmt.Sprintf("%s: local %v, remote %v", name, local.Field(i).Interface(), remote.Field(i).Interface()))
+		}
+	}
+}
+
+// decodes a VIN with the NHTSA vPIC api
+func DecodeVinRemote(ctx context.Context, vin string) (models.VehicleSpec, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, vpicBaseURL+"/DecodeVinValues/"+vin+"?format=json", nil)
+	if err != nil {
+		return models.VehicleSpec{}, err
+	}
+
+	response, err := vpicClient.Do(req)
+	if err != nil {
+		return models.VehicleSpec{}, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return models.VehicleSpec{}, fmt.Errorf("vpic returned status %d", response.StatusCode)
+	}
+
+	var decoded struct {
+		Results []vpicValues `json:"Results"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&decoded); err != nil {
+		return models.VehicleSpec{}, err
+	}
+
+	if len(decoded.Results) == 0 {
+		return models.VehicleSpec{}, ErrVinNotFound
+	}
+
+	return vpicSpec(vin, decoded.Results[0])
+}
+
+// converts vPIC's string values into a VehicleSpec
+func vpicSpec(vin string, values vpicValues) (models.VehicleSpec, error) {
+	year, _ := strconv.Atoi(values.ModelYear)
+
+	//without these the VIN isn't useful for anything else we do
+	if year == 0 || values.Make == "" || values.Model == "" {
+		return models.VehicleSpec{}, ErrVinNotFound
+	}
+
+	spec := models.VehicleSpec{
+		VIN:          vin,
+		Year:         year,
+		Make:         values.Make,
+		Model:        values.Model,
+		Series:       values.Series,
+		Trim:         values.Trim,
+		Manufacturer: values.Manufacturer,
+		VehicleType:  values.VehicleType,
+		BodyClass:    values.BodyClass,
+		Doors:        atoiOrZero(values.Doors),
+		DriveType:    values.DriveType,
+		FuelType:     values.FuelTypePrimary,
+		Transmission: values.TransmissionStyle,
+		Engine: models.EngineSpec{
+			Cylinders:      atoiOrZero(values.EngineCylinders),
+			DisplacementL:  parseFloatOrZero(values.DisplacementL),
+			DisplacementCC: parseFloatOrZero(values.DisplacementCC),
+			Horsepower:     parseFloatOrZero(values.EngineHP),
+			Configuration:  values.EngineConfiguration,
+			Model:          values.EngineModel,
+		},
+		Plant: models.PlantSpec{
+			Country: values.PlantCountry,
+			State:   values.PlantState,
+			City:    values.PlantCity,
+		},
+		GVWR: values.GVWR,
+	}
+
+	//error code 0 means the VIN decoded cleanly, anything else is a warning about part of the VIN
+	if values.ErrorCode != "" && values.ErrorCode != "0" {
+		spec.Notes = values.ErrorText
+	}
+
+	return spec, nil
+}
+
+func atoiOrZero(value string) int {
+	parsed, _ := strconv.Atoi(strings.TrimSpace(value))
+	return parsed
+}
+
+func parseFloatOrZero(value string) float64 {
+	parsed, _ := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	return parsed
+}
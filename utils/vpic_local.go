@@ -0,0 +1,109 @@
+package utils
+
+import (
+	"context"
+	"strconv"
+	"vehicle-api/configs"
+	"vehicle-api/models"
+	"vehicle-api/vin"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var vinPatternCollection *mongo.Collection = configs.GetCollection(configs.DB, "vpic_patterns")
+
+// vPIC element names and where they go in the DecodeVinValues shaped result
+var vpicElements = map[string]func(values *vpicValues, value string){
+	"Make":                             func(v *vpicValues, value string) { v.Make = value },
+	"Model":                            func(v *vpicValues, value string) { v.Model = value },
+	"Series":                           func(v *vpicValues, value string) { v.Series = value },
+	"Trim":                             func(v *vpicValues, value string) { v.Trim = value },
+	"Manufacturer Name":                func(v *vpicValues, value string) { v.Manufacturer = value },
+	"Vehicle Type":                     func(v *vpicValues, value string) { v.VehicleType = value },
+	"Body Class":                       func(v *vpicValues, value string) { v.BodyClass = value },
+	"Doors":                            func(v *vpicValues, value string) { v.Doors = value },
+	"Drive Type":                       func(v *vpicValues, value string) { v.DriveType = value },
+	"Fuel Type - Primary":              func(v *vpicValues, value string) { v.FuelTypePrimary = value },
+	"Transmission Style":               func(v *vpicValues, value string) { v.TransmissionStyle = value },
+	"Engine Number of Cylinders":       func(v *vpicValues, value string) { v.EngineCylinders = value },
+	"Displacement (L)":                 func(v *vpicValues, value string) { v.DisplacementL = value },
+	"Displacement (CC)":                func(v *vpicValues, value string) { v.DisplacementCC = value },
+	"Engine Brake (hp) From":           func(v *vpicValues, value string) { v.EngineHP = value },
+	"Engine Configuration":             func(v *vpicValues, value string) { v.EngineConfiguration = value },
+	"Engine Model":                     func(v *vpicValues, value string) { v.EngineModel = value },
+	"Plant Country":                    func(v *vpicValues, value string) { v.PlantCountry = value },
+	"Plant State":                      func(v *vpicValues, value string) { v.PlantState = value },
+	"Plant City":                       func(v *vpicValues, value string) { v.PlantCity = value },
+	"Gross Vehicle Weight Rating From": func(v *vpicValues, value string) { v.GVWR = value },
+}
+
+// decodes a VIN from the patterns loaded by cmd/vpic-import. returns ErrVinNotFound when the patterns
+// don't cover the VIN, so the caller can fall back to the vPIC api
+func DecodeVinLocal(ctx context.Context, value string) (models.VehicleSpec, error) {
+	info, err := vin.Parse(value)
+	if err != nil {
+		return models.VehicleSpec{}, ErrVinNotFound
+	}
+
+	//small manufacturers can have their own patterns under a 6 character WMI
+	filter := bson.M{"wmi": bson.M{"$in": vin.WMIs(info.VIN)}}
+	if info.ModelYear != 0 {
+		filter["year_from"] = bson.M{"$lte": info.ModelYear}
+		filter["$or"] = bson.A{bson.M{"year_to": 0}, bson.M{"year_to": bson.M{"$gte": info.ModelYear}}}
+	}
+
+	cursor, err := vinPatternCollection.Find(ctx, filter)
+	if err != nil {
+		return models.VehicleSpec{}, err
+	}
+
+	var patterns []models.VinPattern
+	if err := cursor.All(ctx, &patterns); err != nil {
+		return models.VehicleSpec{}, err
+	}
+
+	if len(patterns) == 0 {
+		return models.VehicleSpec{}, ErrVinNotFound
+	}
+
+	//only the most specific WMI's patterns are used, the shared WMI's patterns describe other manufacturers
+	found := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		found[i] = pattern.WMI
+	}
+	wmi := vin.PreferredWMI(info.VIN, found)
+	specific := patterns[:0]
+	for _, pattern := range patterns {
+		if pattern.WMI == wmi {
+			specific = append(specific, pattern)
+		}
+	}
+	patterns = specific
+
+	//positions 4-8 and 10-17, the check digit is left out
+	descriptor := info.VIN[3:8] + "|" + info.VIN[9:]
+
+	//the most specific matching pattern wins for each element
+	best := map[string]models.VinPattern{}
+	bestScore := map[string]int{}
+	for _, pattern := range patterns {
+		score, ok := vin.MatchPattern(pattern.Keys, descriptor)
+		if !ok {
+			continue
+		}
+		if current, found := bestScore[pattern.Element]; !found || score > current {
+			best[pattern.Element] = pattern
+			bestScore[pattern.Element] = score
+		}
+	}
+
+	values := vpicValues{ModelYear: strconv.Itoa(info.ModelYear)}
+	for element, pattern := range best {
+		if set, ok := vpicElements[element]; ok {
+			set(&values, pattern.Value)
+		}
+	}
+
+	return vpicSpec(info.VIN, values)
+}
@@ -0,0 +1,208 @@
+package valuation
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// conditions a vehicle being valued can be in. listings are assumed to be in good condition on average
+const (
+	ConditionExcellent = "excellent"
+	ConditionGood      = "good"
+	ConditionFair      = "fair"
+	ConditionPoor      = "poor"
+)
+
+// kinds of adjustment made to an estimate
+const (
+	AdjustmentTrim      = "trim"
+	AdjustmentCondition = "condition"
+	AdjustmentOption    = "option"
+)
+
+// the adjustment tables used when none are configured
+//
+//go:embed adjustments.json
+var defaultAdjustments []byte
+
+// tables of how trim, condition and equipment change a value
+type Adjustments struct {
+	Conditions      map[string]float64 `json:"conditions"`
+	Trims           map[string]float64 `json:"trims"`
+	Options         map[string]float64 `json:"options"`
+	MinTrimListings int                `json:"min_trim_listings"`
+}
+
+//Conditions and Trims are multipliers, Options are dollar amounts added to the value
+//Trims are only used when there weren't MinTrimListings listings with the same trim to value the car from,
+//otherwise the listings already account for the trim
+
+// an adjustment that was made to an estimate. Amount is what it changed the price by
+type Adjustment struct {
+	Type   string  `json:"type"`
+	Name   string  `json:"name"`
+	Factor float64 `json:"factor,omitempty"`
+	Amount float64 `json:"amount"`
+}
+
+func DefaultAdjustments() Adjustments {
+	adjustments, err := LoadAdjustments(bytes.NewReader(defaultAdjustments))
+	if err != nil {
+		panic("valuation: invalid adjustments.json: " + err.Error())
+	}
+	return adjustments
+}
+
+// reads adjustment tables from JSON in the same shape as adjustments.json. names are matched case insensitively
+func LoadAdjustments(r io.Reader) (Adjustments, error) {
+	var adjustments Adjustments
+	if err := json.NewDecoder(r).Decode(&adjustments); err != nil {
+		return adjustments, err
+	}
+
+	for _, table := range []*map[string]float64{&adjustments.Conditions, &adjustments.Trims, &adjustments.Options} {
+		lowered := make(map[string]float64, len(*table))
+		for name, value := range *table {
+			lowered[strings.ToLower(strings.TrimSpace(name))] = value
+		}
+		*table = lowered
+	}
+
+	for name, factor := range adjustments.Conditions {
+		if factor <= 0 {
+			return adjustments, fmt.Errorf("condition %q must have a factor above 0", name)
+		}
+	}
+	for name, factor := range adjustments.Trims {
+		if factor <= 0 {
+			return adjustments, fmt.Errorf("trim %q must have a factor above 0", name)
+		}
+	}
+	if adjustments.MinTrimListings < 0 {
+		return adjustments, fmt.Errorf("min_trim_listings can't be negative")
+	}
+
+	return adjustments, nil
+}
+
+func (adjustments Adjustments) IsCondition(condition string) bool {
+	_, ok := adjustments.Conditions[strings.ToLower(condition)]
+	return ok
+}
+
+func (adjustments Adjustments) IsOption(option string) bool {
+	_, ok := adjustments.Options[strings.ToLower(option)]
+	return ok
+}
+
+func (adjustments Adjustments) ConditionNames() []string {
+	return sortedKeys(adjustments.Conditions)
+}
+
+func (adjustments Adjustments) OptionNames() []string {
+	return sortedKeys(adjustments.Options)
+}
+
+// the listings with the given trim, or all of them with false when fewer than MinTrimListings have it.
+// a listing has the trim when its trim starts with the same words, so EX matches "EX Sedan" but not "EX-L"
+func (adjustments Adjustments) MatchTrim(listings []Listing, trim string) ([]Listing, bool) {
+	words := strings.Fields(strings.ToLower(trim))
+	if len(words) == 0 {
+		return listings, false
+	}
+
+	matched := []Listing{}
+	for _, listing := range listings {
+		listingWords := strings.Fields(strings.ToLower(listing.Trim))
+		if len(listingWords) < len(words) {
+			continue
+		}
+
+		same := true
+		for i, word := range words {
+			if listingWords[i] != word {
+				same = false
+				break
+			}
+		}
+		if same {
+			matched = append(matched, listing)
+		}
+	}
+
+	if len(matched) == 0 || len(matched) < adjustments.MinTrimListings {
+		return listings, false
+	}
+	return matched, true
+}
+
+// adjusts an estimate for a car with the given trim, condition and options. the trim is only adjusted for
+// when the listings couldn't be matched by trim. factors are applied before options are added, and the
+// intervals are moved with the price
+func (adjustments Adjustments) Apply(estimate Estimate, trim string, trimMatched bool, condition string, options []string) (Estimate, []Adjustment, error) {
+	applied := []Adjustment{}
+	factor := 1.0
+
+	if trim != "" && !trimMatched {
+		if trimFactor, ok := adjustments.Trims[strings.ToLower(trim)]; ok && trimFactor != 1 {
+			applied = append(applied, Adjustment{Type: AdjustmentTrim, Name: trim, Factor: trimFactor, Amount: estimate.Price * factor * (trimFactor - 1)})
+			factor *= trimFactor
+		}
+	}
+
+	if condition != "" {
+		conditionFactor, ok := adjustments.Conditions[strings.ToLower(condition)]
+		if !ok {
+			return estimate, nil, fmt.Errorf("unknown condition %q, available conditions are %s", condition, strings.Join(adjustments.ConditionNames(), ", "))
+		}
+		if conditionFactor != 1 {
+			applied = append(applied, Adjustment{Type: AdjustmentCondition, Name: strings.ToLower(condition), Factor: conditionFactor, Amount: estimate.Price * factor * (conditionFactor - 1)})
+			factor *= conditionFactor
+		}
+	}
+
+	amount := 0.0
+	seen := map[string]bool{}
+	for _, option := range options {
+		option = strings.ToLower(strings.TrimSpace(option))
+		if option == "" || seen[option] {
+			continue
+		}
+		seen[option] = true
+
+		value, ok := adjustments.Options[option]
+		if !ok {
+			return estimate, nil, fmt.Errorf("unknown option %q, available options are %s", option, strings.Join(adjustments.OptionNames(), ", "))
+		}
+		applied = append(applied, Adjustment{Type: AdjustmentOption, Name: option, Amount: value})
+		amount += value
+	}
+
+	adjust := func(value float64) float64 {
+		return math.Max(0, value*factor+amount)
+	}
+
+	estimate.Price = adjust(estimate.Price)
+	intervals := make([]Interval, len(estimate.Intervals))
+	for i, interval := range estimate.Intervals {
+		intervals[i] = Interval{Confidence: interval.Confidence, Low: adjust(interval.Low), High: adjust(interval.High)}
+	}
+	estimate.Intervals = intervals
+
+	return estimate, applied, nil
+}
+
+func sortedKeys(table map[string]float64) []string {
+	keys := make([]string, 0, len(table))
+	for key := range table {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,106 @@
+package valuation
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestConditionChangesValue(t *testing.T) {
+	adjustments := DefaultAdjustments()
+	estimate := Estimate{Price: 20000, Intervals: []Interval{{Confidence: 0.95, Low: 18000, High: 22000}}}
+
+	excellent, _, err := adjustments.Apply(estimate, "", false, ConditionExcellent, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	poor, _, err := adjustments.Apply(estimate, "", false, ConditionPoor, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if excellent.Price <= estimate.Price || poor.Price >= estimate.Price {
+		t.Errorf("excellent %.2f and poor %.2f should be either side of %.2f", excellent.Price, poor.Price, estimate.Price)
+	}
+	if poor.Intervals[0].Low >= estimate.Intervals[0].Low {
+		t.Errorf("interval wasn't moved with the price: %+v", poor.Intervals[0])
+	}
+	if estimate.Intervals[0].Low != 18000 {
+		t.Errorf("the original estimate was changed")
+	}
+}
+
+func TestApplyTrimAndOptions(t *testing.T) {
+	adjustments := Adjustments{
+		Conditions: map[string]float64{"good": 1, "fair": 0.9},
+		Trims:      map[string]float64{"touring": 1.1},
+		Options:    map[string]float64{"sunroof": 500, "awd": 1000},
+	}
+
+	estimate, applied, err := adjustments.Apply(Estimate{Price: 20000}, "Touring", false, "FAIR", []string{"sunroof", "awd", "sunroof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//20000 * 1.1 * 0.9 + 500 + 1000
+	if math.Abs(estimate.Price-21300) > 0.001 {
+		t.Errorf("price = %.2f, want 21300", estimate.Price)
+	}
+
+	total := 0.0
+	for _, adjustment := range applied {
+		total += adjustment.Amount
+	}
+	if len(applied) != 4 || math.Abs(total-1300) > 0.001 {
+		t.Errorf("adjustments = %+v, want 4 adding up to 1300", applied)
+	}
+
+	matched, _, err := adjustments.Apply(Estimate{Price: 20000}, "Touring", true, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched.Price != 20000 {
+		t.Errorf("trim was adjusted for although listings matched it: %.2f", matched.Price)
+	}
+}
+
+func TestApplyRejectsUnknownNames(t *testing.T) {
+	adjustments := DefaultAdjustments()
+
+	if _, _, err := adjustments.Apply(Estimate{Price: 20000}, "", false, "pristine", nil); err == nil || !strings.Contains(err.Error(), "excellent") {
+		t.Errorf("expected an unknown condition error listing the conditions, got %v", err)
+	}
+	if _, _, err := adjustments.Apply(Estimate{Price: 20000}, "", false, "", []string{"jetpack"}); err == nil {
+		t.Errorf("expected an unknown option error")
+	}
+}
+
+func TestMatchTrim(t *testing.T) {
+	listings := []Listing{
+		{Trim: "EX Sedan"}, {Trim: "EX"}, {Trim: "ex"}, {Trim: "EX-L"}, {Trim: "LX"}, {Trim: ""},
+	}
+
+	matched, ok := Adjustments{MinTrimListings: 3}.MatchTrim(listings, "EX")
+	if !ok || len(matched) != 3 {
+		t.Errorf("matched %d listings (%v), want 3", len(matched), ok)
+	}
+
+	all, ok := Adjustments{MinTrimListings: 4}.MatchTrim(listings, "EX")
+	if ok || len(all) != len(listings) {
+		t.Errorf("expected every listing back when too few match, got %d (%v)", len(all), ok)
+	}
+}
+
+func TestLoadAdjustments(t *testing.T) {
+	adjustments, err := LoadAdjustments(strings.NewReader(`{"conditions":{"Good":1},"options":{"Sunroof":400}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !adjustments.IsCondition("good") || !adjustments.IsOption("SUNROOF") {
+		t.Errorf("names should be matched case insensitively: %+v", adjustments)
+	}
+
+	if _, err := LoadAdjustments(strings.NewReader(`{"conditions":{"poor":0}}`)); err == nil {
+		t.Errorf("expected a factor of 0 to be rejected")
+	}
+}
@@ -0,0 +1,113 @@
+package valuation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const autoTraderBaseURL = "https://www.autotrader.com"
+
+var yearPattern = regexp.MustCompile(`[0-9]{4}`)
+
+// scrapes AutoTrader's search results page
+type AutoTraderSource struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewAutoTraderSource() *AutoTraderSource {
+	return &AutoTraderSource{
+		BaseURL: autoTraderBaseURL,
+		Client:  &http.Client{Timeout: 20 * time.Second},
+	}
+}
+
+func (s *AutoTraderSource) Name() string {
+	return "autotrader"
+}
+
+// builds the search results url, e.g. /cars-for-sale/all-cars/2021/acura/ilx?numRecords=100&searchRadius=100&zip=12345
+func (s *AutoTraderSource) searchURL(query SearchQuery) string {
+	yearSegment := strconv.Itoa(query.Year) + "/"
+	if query.MultipleYears {
+		yearSegment = ""
+	}
+
+	radius := query.Radius
+	if radius == 0 {
+		radius = DefaultRadius
+	}
+
+	return s.BaseURL + "/cars-for-sale/all-cars/" + yearSegment + slug(query.Make) + "/" + slug(query.Model) +
+		"?numRecords=100&searchRadius=" + strconv.Itoa(radius) + "&zip=" + query.ZipCode
+}
+
+func slug(value string) string {
+	return strings.ReplaceAll(strings.ToLower(value), " ", "-")
+}
+
+func (s *AutoTraderSource) Search(ctx context.Context, query SearchQuery) ([]Listing, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.searchURL(query), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	response, err := s.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search returned status %d", response.StatusCode)
+	}
+
+	listings, err := parseAutoTrader(response.Body, s.BaseURL, query)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range listings {
+		listings[i].Source = s.Name()
+	}
+
+	return listings, nil
+}
+
+func titleYear(title string) (int, error) {
+	match := yearPattern.FindString(title)
+	if match == "" {
+		return 0, errors.New("no year in listing title " + strconv.Quote(title))
+	}
+	return strconv.Atoi(match)
+}
+
+func titleCondition(title string) string {
+	switch {
+	case strings.HasPrefix(title, "New"):
+		return ConditionNew
+	case strings.HasPrefix(title, "Certified"):
+		return ConditionCertified
+	case strings.HasPrefix(title, "Used"):
+		return ConditionUsed
+	}
+	return ""
+}
+
+// whatever follows the make and model in the title
+func titleTrim(title string, query SearchQuery) string {
+	lower := strings.ToLower(title)
+	prefix := strings.ToLower(query.Make + " " + query.Model)
+
+	index := strings.Index(lower, prefix)
+	if index == -1 {
+		return ""
+	}
+	return strings.TrimSpace(title[index+len(prefix):])
+}
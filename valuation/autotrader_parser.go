@@ -0,0 +1,222 @@
+package valuation
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+var distancePattern = regexp.MustCompile(`([0-9,]+) mi\.? away`)
+
+// what AutoTrader shows instead of cards when nothing matches the search, e.g. "0 Results" or "No results found"
+var noResultsPattern = regexp.MustCompile(`(?i)(^|[^0-9,])0\s+(results|matches|vehicles)\b|no\s+(exact\s+)?(results|matches)\s+found`)
+
+var ErrNoListingCards = errors.New("no listing cards found, the page markup may have changed")
+
+// parses an AutoTrader search results page. each card is read on its own so a card with a missing field
+// can't shift values onto its neighbours. sponsored cards and cards without a price, mileage or year are
+// skipped, relative listing urls are resolved against baseURL. a page saying nothing matched gives no listings,
+// any other page without a readable card is an error
+func parseAutoTrader(r io.Reader, baseURL string, query SearchQuery) ([]Listing, error) {
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cards := findAll(doc, func(n *html.Node) bool {
+		return isElement(n, "div") && hasClass(n, "item-card")
+	})
+
+	if len(cards) == 0 {
+		if noResultsPattern.MatchString(visibleText(doc)) {
+			return []Listing{}, nil
+		}
+		return nil, ErrNoListingCards
+	}
+
+	listings := []Listing{}
+	for _, card := range cards {
+		if listing, ok := parseAutoTraderCard(card, baseURL, query); ok {
+			listings = append(listings, listing)
+		}
+	}
+
+	//cards that all fail to parse are more likely a markup change than a page of unusual listings
+	if len(listings) == 0 {
+		return nil, fmt.Errorf("found %d listing cards but none could be read, the page markup may have changed", len(cards))
+	}
+
+	return listings, nil
+}
+
+// returns false for cards that aren't usable listings
+func parseAutoTraderCard(card *html.Node, baseURL string, query SearchQuery) (Listing, bool) {
+	if hasClass(card, "sponsored") || findFirst(card, func(n *html.Node) bool {
+		return n.Type == html.ElementNode && strings.TrimSpace(textOf(n)) == "Sponsored"
+	}) != nil {
+		return Listing{}, false
+	}
+
+	titleNode := findFirst(card, func(n *html.Node) bool { return isElement(n, "h3") && hasClass(n, "text-bold") })
+	if titleNode == nil {
+		return Listing{}, false
+	}
+	title := strings.Join(strings.Fields(textOf(titleNode)), " ")
+
+	year, err := titleYear(title)
+	if err != nil {
+		return Listing{}, false
+	}
+
+	//dealer discounts show a second, crossed out price after the first one, only the first is the asking price
+	priceNode := findFirst(card, func(n *html.Node) bool { return isElement(n, "span") && hasClass(n, "first-price") })
+	if priceNode == nil {
+		return Listing{}, false
+	}
+	//some cards say "Call for price" instead
+	price, err := parseNumber(textOf(priceNode))
+	if err != nil {
+		return Listing{}, false
+	}
+
+	mileageNode := findFirst(card, func(n *html.Node) bool {
+		return isElement(n, "span") && hasClass(n, "text-bold") && strings.HasSuffix(strings.TrimSpace(textOf(n)), " miles")
+	})
+	if mileageNode == nil {
+		return Listing{}, false
+	}
+	mileage, err := parseNumber(strings.TrimSuffix(strings.TrimSpace(textOf(mileageNode)), " miles"))
+	if err != nil {
+		return Listing{}, false
+	}
+
+	listing := Listing{
+		Title:     title,
+		Price:     price,
+		Mileage:   mileage,
+		Year:      year,
+		Make:      query.Make,
+		Model:     query.Model,
+		Trim:      titleTrim(title, query),
+		Condition: titleCondition(title),
+	}
+
+	if link := findFirst(card, func(n *html.Node) bool {
+		return isElement(n, "a") && strings.Contains(attr(n, "href"), "/cars-for-sale/vehicle")
+	}); link != nil {
+		listing.URL = attr(link, "href")
+		if strings.HasPrefix(listing.URL, "/") {
+			listing.URL = baseURL + listing.URL
+		}
+	}
+
+	if vin := attr(card, "data-vin"); vin != "" {
+		listing.VIN = strings.ToUpper(vin)
+	}
+
+	if findFirst(card, func(n *html.Node) bool { return hasClass(n, "dealer-name") }) != nil {
+		listing.SellerType = SellerDealer
+	} else if strings.Contains(textOf(card), "Private Seller") {
+		listing.SellerType = SellerPrivate
+	}
+
+	if location := findFirst(card, func(n *html.Node) bool { return hasClass(n, "listing-location") }); location != nil {
+		listing.Location = strings.TrimSpace(textOf(location))
+	}
+
+	//e.g. "12 mi. away"
+	if distance := distancePattern.FindStringSubmatch(textOf(card)); distance != nil {
+		listing.Distance, _ = parseNumber(distance[1])
+	}
+
+	return listing, true
+}
+
+// parses numbers like "$23,995"
+func parseNumber(value string) (int, error) {
+	value = strings.NewReplacer("$", "", ",", "").Replace(strings.TrimSpace(value))
+	return strconv.Atoi(value)
+}
+
+func isElement(n *html.Node, tag string) bool {
+	return n.Type == html.ElementNode && n.Data == tag
+}
+
+func hasClass(n *html.Node, class string) bool {
+	for _, value := range strings.Fields(attr(n, "class")) {
+		if value == class {
+			return true
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// concatenated text of a node and its children
+func textOf(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+
+	var text strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(textOf(child))
+	}
+	return text.String()
+}
+
+// the text of a page without scripts and styles, which can mention anything
+func visibleText(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	if isElement(n, "script") || isElement(n, "style") {
+		return ""
+	}
+
+	var text strings.Builder
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		text.WriteString(visibleText(child))
+		text.WriteString(" ")
+	}
+	return text.String()
+}
+
+// finds the outermost nodes that match, matches inside a match aren't returned
+func findAll(n *html.Node, match func(*html.Node) bool) []*html.Node {
+	if match(n) {
+		return []*html.Node{n}
+	}
+
+	found := []*html.Node{}
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		found = append(found, findAll(child, match)...)
+	}
+	return found
+}
+
+// depth first search for the first matching node below n
+func findFirst(n *html.Node, match func(*html.Node) bool) *html.Node {
+	for child := n.FirstChild; child != nil; child = child.NextSibling {
+		if match(child) {
+			return child
+		}
+		if found := findFirst(child, match); found != nil {
+			return found
+		}
+	}
+	return nil
+}
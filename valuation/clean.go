@@ -0,0 +1,243 @@
+package valuation
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// why a listing was left out of a valuation
+const (
+	DroppedCondition   = "condition"
+	DroppedSalvage     = "salvage"
+	DroppedPlaceholder = "placeholder_price"
+	DroppedMileage     = "implausible_mileage"
+	DroppedDuplicate   = "duplicate"
+	DroppedOutlier     = "price_outlier"
+	DroppedTrim        = "different_trim"
+)
+
+// how price outliers are found
+const (
+	OutliersIQR  = "iqr"
+	OutliersMAD  = "mad"
+	OutliersNone = "none"
+)
+
+// words in a title that mean the car has a branded title or isn't really for sale as a car
+var salvageWords = []string{"salvage", "rebuilt", "reconstructed", "flood", "lemon", "frame damage", "parts only", "for parts"}
+
+type CleanOptions struct {
+	ExcludeConditions []string
+	MinPrice          int
+	MaxMilesPerYear   int
+	MaxMileage        int
+	Outliers          string
+	IQRMultiplier     float64
+	MADThreshold      float64
+}
+
+//MaxMilesPerYear is multiplied by the age of the car plus one, so a car from this year can have a year's worth of miles
+//MADThreshold is the modified z-score above which a price is an outlier, 3.5 is the usual cut off
+
+func DefaultCleanOptions() CleanOptions {
+	return CleanOptions{
+		ExcludeConditions: []string{ConditionNew, ConditionCertified},
+		MinPrice:          500,
+		MaxMilesPerYear:   30000,
+		MaxMileage:        500000,
+		Outliers:          OutliersIQR,
+		IQRMultiplier:     1.5,
+		MADThreshold:      3.5,
+	}
+}
+
+// a listing that was left out and why
+type ExcludedListing struct {
+	Listing
+	Reason string `json:"reason"`
+}
+
+type CleanResult struct {
+	Kept     []Listing         `json:"-"`
+	Excluded []ExcludedListing `json:"-"`
+	Dropped  map[string]int    `json:"dropped"`
+}
+
+// removes listings that would skew a valuation. currentYear is used to work out how many miles a car can
+// plausibly have. price outliers are looked for per model year when a year has enough listings, since a
+// multi year search is expected to have a wide spread of prices
+func Clean(listings []Listing, currentYear int, options CleanOptions) CleanResult {
+	result := CleanResult{Kept: []Listing{}, Excluded: []ExcludedListing{}, Dropped: map[string]int{}}
+
+	exclude := func(listing Listing, reason string) {
+		result.Excluded = append(result.Excluded, ExcludedListing{Listing: listing, Reason: reason})
+		result.Dropped[reason]++
+	}
+
+	seen := map[string]bool{}
+	candidates := []Listing{}
+
+	for _, listing := range listings {
+		switch {
+		case containsString(options.ExcludeConditions, listing.Condition):
+			exclude(listing, DroppedCondition)
+		case isSalvage(listing.Title):
+			exclude(listing, DroppedSalvage)
+		case listing.Price < options.MinPrice:
+			exclude(listing, DroppedPlaceholder)
+		case !plausibleMileage(listing, currentYear, options):
+			exclude(listing, DroppedMileage)
+		case seen[duplicateKey(listing)]:
+			exclude(listing, DroppedDuplicate)
+		default:
+			seen[duplicateKey(listing)] = true
+			candidates = append(candidates, listing)
+		}
+	}
+
+	outliers := priceOutliers(candidates, options)
+	for i, listing := range candidates {
+		if outliers[i] {
+			exclude(listing, DroppedOutlier)
+			continue
+		}
+		result.Kept = append(result.Kept, listing)
+	}
+
+	return result
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func isSalvage(title string) bool {
+	title = strings.ToLower(title)
+	for _, word := range salvageWords {
+		if strings.Contains(title, word) {
+			return true
+		}
+	}
+	return false
+}
+
+func plausibleMileage(listing Listing, currentYear int, options CleanOptions) bool {
+	if listing.Mileage < 0 || (options.MaxMileage > 0 && listing.Mileage > options.MaxMileage) {
+		return false
+	}
+	if options.MaxMilesPerYear == 0 {
+		return true
+	}
+
+	age := currentYear - listing.Year
+	if age < 0 {
+		age = 0
+	}
+	return listing.Mileage <= options.MaxMilesPerYear*(age+1)
+}
+
+// the same car is often listed twice, on different sources or by a dealer reposting it
+func duplicateKey(listing Listing) string {
+	if listing.VIN != "" {
+		return "vin:" + strings.ToUpper(listing.VIN)
+	}
+	return "listing:" + strings.ToLower(listing.Title) + ":" + strconv.Itoa(listing.Price) + ":" + strconv.Itoa(listing.Mileage)
+}
+
+// flags price outliers, per model year when the year has at least 8 listings and across all listings otherwise
+func priceOutliers(listings []Listing, options CleanOptions) []bool {
+	outliers := make([]bool, len(listings))
+	if options.Outliers == OutliersNone || options.Outliers == "" {
+		return outliers
+	}
+
+	byYear := map[int][]int{}
+	for i, listing := range listings {
+		byYear[listing.Year] = append(byYear[listing.Year], i)
+	}
+
+	groups := [][]int{}
+	rest := []int{}
+	for _, indexes := range byYear {
+		if len(indexes) >= 8 {
+			groups = append(groups, indexes)
+		} else {
+			rest = append(rest, indexes...)
+		}
+	}
+	if len(rest) > 0 {
+		groups = append(groups, rest)
+	}
+
+	for _, group := range groups {
+		//too few listings to tell an outlier from a small sample
+		if len(group) < 4 {
+			continue
+		}
+
+		prices := make([]float64, len(group))
+		for i, index := range group {
+			prices[i] = float64(listings[index].Price)
+		}
+
+		var isOutlier func(float64) bool
+		if options.Outliers == OutliersMAD {
+			isOutlier = madOutlier(prices, options.MADThreshold)
+		} else {
+			isOutlier = iqrOutlier(prices, options.IQRMultiplier)
+		}
+
+		for i, index := range group {
+			outliers[index] = isOutlier(prices[i])
+		}
+	}
+
+	return outliers
+}
+
+// tukey's fences, outside [q1 - k*iqr, q3 + k*iqr]
+func iqrOutlier(values []float64, multiplier float64) func(float64) bool {
+	q1, q3 := quantile(values, 0.25), quantile(values, 0.75)
+	iqr := q3 - q1
+	low, high := q1-multiplier*iqr, q3+multiplier*iqr
+
+	return func(value float64) bool {
+		return value < low || value > high
+	}
+}
+
+// modified z-score of Iglewicz and Hoaglin, 0.6745 * |x - median| / MAD
+func madOutlier(values []float64, threshold float64) func(float64) bool {
+	median := quantile(values, 0.5)
+	mad := medianAbsoluteDeviation(values)
+
+	return func(value float64) bool {
+		if mad == 0 {
+			return false
+		}
+		return 0.6745*math.Abs(value-median)/mad > threshold
+	}
+}
+
+// linear interpolation between closest ranks, the same as numpy's default
+func quantile(values []float64, q float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := append([]float64{}, values...)
+	sort.Float64s(sorted)
+
+	position := q * float64(len(sorted)-1)
+	lower := int(math.Floor(position))
+	upper := int(math.Ceil(position))
+
+	return sorted[lower] + (sorted[upper]-sorted[lower])*(position-float64(lower))
+}
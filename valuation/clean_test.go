@@ -0,0 +1,79 @@
+package valuation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestClean(t *testing.T) {
+	listings := []Listing{
+		{Title: "Used 2019 Honda Accord LX", Price: 19500, Mileage: 40000, Year: 2019, Condition: ConditionUsed},
+		{Title: "Used 2019 Honda Accord Sport", Price: 20500, Mileage: 35000, Year: 2019, Condition: ConditionUsed},
+		{Title: "Used 2019 Honda Accord EX", Price: 21000, Mileage: 30000, Year: 2019, Condition: ConditionUsed},
+		{Title: "Used 2019 Honda Accord EX-L", Price: 21800, Mileage: 28000, Year: 2019, Condition: ConditionUsed},
+		{Title: "Used 2019 Honda Accord Touring", Price: 23000, Mileage: 25000, Year: 2019, Condition: ConditionUsed},
+		{Title: "Used 2019 Honda Accord Touring", Price: 23000, Mileage: 25000, Year: 2019, Condition: ConditionUsed},
+		{Title: "Used 2019 Honda Accord LX", Price: 61000, Mileage: 41000, Year: 2019, Condition: ConditionUsed},
+		{Title: "New 2024 Honda Accord EX", Price: 31000, Mileage: 5, Year: 2024, Condition: ConditionNew},
+		{Title: "Certified 2019 Honda Accord LX", Price: 22500, Mileage: 30000, Year: 2019, Condition: ConditionCertified},
+		{Title: "Used 2019 Honda Accord LX Rebuilt Title", Price: 9000, Mileage: 50000, Year: 2019, Condition: ConditionUsed},
+		{Title: "Used 2019 Honda Accord Sport", Price: 1, Mileage: 30000, Year: 2019, Condition: ConditionUsed},
+		{Title: "Used 2019 Honda Accord EX", Price: 20000, Mileage: 999999, Year: 2019, Condition: ConditionUsed},
+		{Title: "Used 2023 Honda Accord EX", Price: 27000, Mileage: 95000, Year: 2023, Condition: ConditionUsed},
+	}
+
+	result := Clean(listings, 2024, DefaultCleanOptions())
+
+	want := map[string]int{
+		DroppedCondition:   2,
+		DroppedSalvage:     1,
+		DroppedPlaceholder: 1,
+		DroppedMileage:     2,
+		DroppedDuplicate:   1,
+		DroppedOutlier:     1,
+	}
+	if !reflect.DeepEqual(result.Dropped, want) {
+		t.Errorf("dropped %v, want %v", result.Dropped, want)
+	}
+
+	if len(result.Kept) != 5 {
+		t.Errorf("kept %d listings, want 5", len(result.Kept))
+	}
+	if len(result.Excluded)+len(result.Kept) != len(listings) {
+		t.Errorf("%d excluded and %d kept don't add up to %d listings", len(result.Excluded), len(result.Kept), len(listings))
+	}
+	for _, excluded := range result.Excluded {
+		if excluded.Reason == DroppedOutlier && excluded.Price != 61000 {
+			t.Errorf("%d flagged as an outlier, want only 61000", excluded.Price)
+		}
+	}
+}
+
+func TestCleanMAD(t *testing.T) {
+	listings := []Listing{}
+	for _, price := range []int{18000, 18500, 19000, 19200, 19800, 20100, 45000} {
+		listings = append(listings, Listing{Price: price, Mileage: 30000, Year: 2019, Title: "Used " + string(rune('A'+len(listings)))})
+	}
+
+	options := DefaultCleanOptions()
+	options.Outliers = OutliersMAD
+
+	result := Clean(listings, 2024, options)
+	if result.Dropped[DroppedOutlier] != 1 || result.Excluded[0].Price != 45000 {
+		t.Errorf("got %v, want only 45000 dropped as an outlier", result.Excluded)
+	}
+
+	options.Outliers = OutliersNone
+	if result := Clean(listings, 2024, options); len(result.Kept) != len(listings) {
+		t.Errorf("kept %d listings with outlier rejection off, want %d", len(result.Kept), len(listings))
+	}
+}
+
+func TestQuantile(t *testing.T) {
+	values := []float64{7, 1, 3, 5}
+	for q, want := range map[float64]float64{0: 1, 0.25: 2.5, 0.5: 4, 1: 7} {
+		if got := quantile(values, q); got != want {
+			t.Errorf("quantile(%v) = %v, want %v", q, got, want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package valuation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// a listing found for a valuation, Excluded listings weren't used to fit it
+type Comparable struct {
+	Listing
+	Excluded bool   `json:"excluded"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+//Reason is one of the Dropped constants
+
+// fields comparables can be sorted by
+var ComparableSortFields = []string{"price", "mileage", "year", "distance"}
+
+// sorts comparables by a field, keeping the order of equal ones. listings without a distance always sort
+// after the ones with one
+func SortComparables(comparables []Comparable, field string, descending bool) error {
+	var value func(comparable Comparable) int
+
+	switch field {
+	case "price":
+		value = func(comparable Comparable) int { return comparable.Price }
+	case "mileage":
+		value = func(comparable Comparable) int { return comparable.Mileage }
+	case "year":
+		value = func(comparable Comparable) int { return comparable.Year }
+	case "distance":
+		value = func(comparable Comparable) int { return comparable.Distance }
+	default:
+		return fmt.Errorf("can't sort by %q, comparables can be sorted by %s", field, strings.Join(ComparableSortFields, ", "))
+	}
+
+	sort.SliceStable(comparables, func(i, j int) bool {
+		a, b := value(comparables[i]), value(comparables[j])
+
+		if field == "distance" && (a == 0) != (b == 0) {
+			return b == 0
+		}
+
+		if descending {
+			return a > b
+		}
+		return a < b
+	})
+
+	return nil
+}
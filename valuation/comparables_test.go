@@ -0,0 +1,47 @@
+package valuation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSortComparables(t *testing.T) {
+	comparables := []Comparable{
+		{Listing: Listing{VIN: "A", Price: 20000, Distance: 30}},
+		{Listing: Listing{VIN: "B", Price: 18000}},
+		{Listing: Listing{VIN: "C", Price: 20000, Distance: 5}},
+		{Listing: Listing{VIN: "D", Price: 25000, Distance: 12}},
+	}
+
+	vins := func() []string {
+		result := []string{}
+		for _, comparable := range comparables {
+			result = append(result, comparable.VIN)
+		}
+		return result
+	}
+
+	tests := []struct {
+		field      string
+		descending bool
+		want       []string
+	}{
+		{"price", false, []string{"B", "A", "C", "D"}},
+		{"price", true, []string{"D", "A", "C", "B"}},
+		{"distance", false, []string{"C", "D", "A", "B"}},
+		{"distance", true, []string{"A", "D", "C", "B"}},
+	}
+
+	for _, test := range tests {
+		if err := SortComparables(comparables, test.field, test.descending); err != nil {
+			t.Fatal(err)
+		}
+		if got := vins(); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("sort by %s (descending %v) = %v, want %v", test.field, test.descending, got, test.want)
+		}
+	}
+
+	if err := SortComparables(comparables, "color", false); err == nil {
+		t.Errorf("expected an error sorting by an unknown field")
+	}
+}
@@ -0,0 +1,77 @@
+package valuation
+
+import (
+	"math"
+)
+
+// the cross sectional models know what cars of every age and mileage sell for today, so a car's value in
+// k years is forecast as the value today of the same car k model years older with k more years of miles.
+// this assumes prices don't drift as a whole, which is the usual assumption for residual values
+
+// a point on a residual or mileage curve
+type CurvePoint struct {
+	YearsFromNow int        `json:"years_from_now"`
+	Mileage      int        `json:"mileage"`
+	Price        float64    `json:"predicted_price"`
+	Intervals    []Interval `json:"prediction_intervals"`
+	Residual     float64    `json:"residual_percent"`
+	Extrapolated bool       `json:"extrapolated"`
+}
+
+//Residual is the price as a percentage of the current value
+//Extrapolated is set when the point is older or has more miles than any listing, the further out the less it
+//can be trusted even though the intervals widen
+
+// the car in each of the next years, starting with today
+func ResidualTargets(current Point, annualMileage float64, years int) []Point {
+	targets := make([]Point, years+1)
+	for k := 0; k <= years; k++ {
+		targets[k] = Point{Year: current.Year - float64(k), Mileage: current.Mileage + float64(k)*annualMileage}
+	}
+	return targets
+}
+
+// the car at every step of mileage from 0 up to and including maxMileage
+func MileageTargets(year float64, maxMileage float64, step float64) []Point {
+	targets := []Point{}
+	if step <= 0 {
+		return targets
+	}
+	for mileage := 0.0; mileage <= maxMileage; mileage += step {
+		targets = append(targets, Point{Year: year, Mileage: mileage})
+	}
+	return targets
+}
+
+// true when the target is older or has more miles than every point, or is newer or has fewer miles than every point
+func Extrapolated(points []Point, target Point) bool {
+	if len(points) == 0 {
+		return true
+	}
+
+	minYear, maxYear := points[0].Year, points[0].Year
+	minMileage, maxMileage := points[0].Mileage, points[0].Mileage
+	for _, point := range points {
+		minYear, maxYear = math.Min(minYear, point.Year), math.Max(maxYear, point.Year)
+		minMileage, maxMileage = math.Min(minMileage, point.Mileage), math.Max(maxMileage, point.Mileage)
+	}
+
+	return target.Year < minYear || target.Year > maxYear || target.Mileage < minMileage || target.Mileage > maxMileage
+}
+
+// turns estimates for targets into a curve, residuals are relative to currentPrice
+func Curve(points []Point, targets []Point, estimates []Estimate, currentPrice float64) []CurvePoint {
+	curve := make([]CurvePoint, len(estimates))
+	for i, estimate := range estimates {
+		curve[i] = CurvePoint{
+			Mileage:      int(math.Round(targets[i].Mileage)),
+			Price:        estimate.Price,
+			Intervals:    estimate.Intervals,
+			Extrapolated: Extrapolated(points, targets[i]),
+		}
+		if currentPrice > 0 {
+			curve[i].Residual = estimate.Price / currentPrice * 100
+		}
+	}
+	return curve
+}
@@ -0,0 +1,99 @@
+package valuation
+
+import (
+	"math"
+	"testing"
+)
+
+func TestResidualsDecline(t *testing.T) {
+	points := depreciationPoints()
+	current := Point{Year: 2019, Mileage: 20000}
+	targets := ResidualTargets(current, 12000, 8)
+
+	if len(targets) != 9 || targets[3].Year != 2016 || targets[3].Mileage != 56000 {
+		t.Fatalf("targets = %+v", targets)
+	}
+
+	//the parametric models keep losing value past the data, knn can only repeat the oldest listings it has
+	for _, name := range []string{"log", "linear", "huber", "knn"} {
+		estimates, err := ForecastPrices(points, targets, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		curve := Curve(points, targets, estimates, estimates[0].Price)
+		if curve[0].Residual != 100 {
+			t.Errorf("%s: today's residual is %.2f, want 100", name, curve[0].Residual)
+		}
+		for k := 1; k < len(curve); k++ {
+			if curve[k].Price > curve[k-1].Price || (name != "knn" && curve[k].Price == curve[k-1].Price) {
+				t.Errorf("%s: value doesn't go down from year %d to %d: %.2f to %.2f", name, k-1, k, curve[k-1].Price, curve[k].Price)
+			}
+		}
+	}
+}
+
+func TestForecastPricesAreNotClamped(t *testing.T) {
+	points := depreciationPoints()
+	low := points[0].Price
+	for _, point := range points {
+		low = math.Min(low, point.Price)
+	}
+
+	//ten years and 200,000 miles past the oldest listing
+	targets := []Point{{Year: 2006, Mileage: 340000}}
+
+	clamped, err := EstimatePrices(points, targets, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forecast, err := ForecastPrices(points, targets, "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if clamped[0].Price != low {
+		t.Errorf("valuation is %.2f, want it clamped to the lowest price %.2f", clamped[0].Price, low)
+	}
+	if forecast[0].Price >= low || forecast[0].Price <= 0 || forecast[0].Clamped {
+		t.Errorf("forecast is %.2f, want it below the lowest price %.2f and above 0", forecast[0].Price, low)
+	}
+	if !Extrapolated(points, targets[0]) {
+		t.Errorf("the target wasn't marked as extrapolated")
+	}
+
+	//a straight line goes below zero this far out
+	linear, err := ForecastPrices(points, []Point{{Year: 1990, Mileage: 900000}}, "linear")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linear[0].Price != 0 {
+		t.Errorf("linear forecast is %.2f, want it floored at 0", linear[0].Price)
+	}
+	for _, interval := range linear[0].Intervals {
+		if interval.Low < 0 || interval.High < 0 {
+			t.Errorf("interval %+v goes below 0", interval)
+		}
+	}
+}
+
+func TestExtrapolated(t *testing.T) {
+	points := depreciationPoints()
+
+	if Extrapolated(points, Point{Year: 2017, Mileage: 60000}) {
+		t.Errorf("a point inside the data was marked as extrapolated")
+	}
+	if !Extrapolated(points, Point{Year: 2012, Mileage: 60000}) {
+		t.Errorf("a point older than the data wasn't marked as extrapolated")
+	}
+	if !Extrapolated(points, Point{Year: 2017, Mileage: 300000}) {
+		t.Errorf("a point with more miles than the data wasn't marked as extrapolated")
+	}
+}
+
+func TestMileageTargets(t *testing.T) {
+	targets := MileageTargets(2019, 50000, 10000)
+	if len(targets) != 6 || targets[5].Mileage != 50000 {
+		t.Errorf("targets = %+v", targets)
+	}
+}
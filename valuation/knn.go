@@ -0,0 +1,138 @@
+package valuation
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// values a car from the prices of the most similar listings, with no assumption about the shape of
+// depreciation. mileage and year are scaled by their spread so neither dominates the distance
+type KNNModel struct {
+	points       []Point
+	k            int
+	mileageScale float64
+	yearScale    float64
+	rSquared     float64
+	rmse         float64
+}
+
+func FitKNN(points []Point) (*KNNModel, error) {
+	if len(points) < 3 {
+		return nil, fmt.Errorf("%w, knn needs at least 3 listings", ErrNotEnoughListings)
+	}
+
+	mileages := make([]float64, len(points))
+	years := make([]float64, len(points))
+	for i, point := range points {
+		mileages[i] = point.Mileage
+		years[i] = point.Year
+	}
+
+	model := &KNNModel{
+		points:       points,
+		k:            int(math.Max(3, math.Min(10, math.Round(math.Sqrt(float64(len(points))))))),
+		mileageScale: scaleOf(mileages),
+		yearScale:    scaleOf(years),
+	}
+
+	//leave one out predictions give an honest r squared, predicting a listing from itself would be perfect
+	meanPrice := 0.0
+	for _, point := range points {
+		meanPrice += point.Price / float64(len(points))
+	}
+
+	sse, sst := 0.0, 0.0
+	for i, point := range points {
+		residual := point.Price - model.predict(point, i)
+		sse += residual * residual
+		sst += (point.Price - meanPrice) * (point.Price - meanPrice)
+	}
+
+	model.rmse = math.Sqrt(sse / float64(len(points)))
+	if sst > 0 {
+		model.rSquared = 1 - sse/sst
+	}
+
+	return model, nil
+}
+
+// standard deviation, or 1 when a feature doesn't vary so it is simply ignored
+func scaleOf(values []float64) float64 {
+	if deviation := math.Sqrt(variance(values)); deviation > 0 {
+		return deviation
+	}
+	return 1
+}
+
+func (m *KNNModel) Name() string {
+	return "knn"
+}
+
+// the k listings closest to target, skipping the point at index skip (-1 to keep them all)
+func (m *KNNModel) neighbours(target Point, skip int) []Point {
+	type candidate struct {
+		point    Point
+		distance float64
+	}
+
+	candidates := make([]candidate, 0, len(m.points))
+	for i, point := range m.points {
+		if i == skip {
+			continue
+		}
+		mileage := (point.Mileage - target.Mileage) / m.mileageScale
+		year := (point.Year - target.Year) / m.yearScale
+		candidates = append(candidates, candidate{point: point, distance: math.Sqrt(mileage*mileage + year*year)})
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].distance < candidates[j].distance })
+
+	k := m.k
+	if k > len(candidates) {
+		k = len(candidates)
+	}
+
+	neighbours := make([]Point, k)
+	for i := 0; i < k; i++ {
+		neighbours[i] = candidates[i].point
+	}
+	return neighbours
+}
+
+func (m *KNNModel) predict(target Point, skip int) float64 {
+	neighbours := m.neighbours(target, skip)
+	prices := make([]float64, len(neighbours))
+	for i, neighbour := range neighbours {
+		prices[i] = neighbour.Price
+	}
+	return mean(prices)
+}
+
+// the mean price of the nearest listings. intervals are the spread of the neighbours' prices widened by the
+// leave one out error, since a handful of neighbours understates how far a single car can be from them
+func (m *KNNModel) Estimate(target Point) Estimate {
+	neighbours := m.neighbours(target, -1)
+	prices := make([]float64, len(neighbours))
+	for i, neighbour := range neighbours {
+		prices[i] = neighbour.Price
+	}
+	price := mean(prices)
+
+	intervals := make([]Interval, len(ConfidenceLevels))
+	for i, level := range ConfidenceLevels {
+		margin := normalQuantile(0.5+level/2) * m.rmse
+		low := math.Min(quantile(prices, (1-level)/2), price-margin)
+		high := math.Max(quantile(prices, 1-(1-level)/2), price+margin)
+		intervals[i] = Interval{Confidence: level, Low: low, High: high}
+	}
+
+	return Estimate{
+		Model:            m.Name(),
+		Price:            price,
+		Intervals:        intervals,
+		RSquared:         m.rSquared,
+		ResidualStdError: m.rmse,
+		Observations:     len(m.points),
+	}
+}
@@ -0,0 +1,266 @@
+package valuation
+
+import (
+	"fmt"
+	"math"
+)
+
+// huber's tuning constant, gives 95% efficiency when the errors are normal
+const huberK = 1.345
+
+// least squares fit of price ~ mileage + year. features that don't vary (e.g. year when only one year was
+// searched) are left out rather than making the fit fail. the same fit is used on log price and with
+// huber weights for the log and huber models
+type LinearModel struct {
+	name         string
+	logPrice     bool
+	features     []string
+	means        map[string]float64
+	coefficients []float64
+	xtxInverse   [][]float64
+	sigma        float64
+	df           float64
+	rSquared     float64
+	priceSigma   float64
+	observations int
+}
+
+func featureValue(point Point, feature string) float64 {
+	if feature == "mileage" {
+		return point.Mileage
+	}
+	return point.Year
+}
+
+// price ~ mileage + year
+func FitLinear(points []Point) (*LinearModel, error) {
+	return fitRegression("linear", points, false, false)
+}
+
+// log(price) ~ mileage + year, prices fall by a share of the price per mile and year instead of a fixed
+// amount so old and high mileage cars can't go below zero
+func FitLogLinear(points []Point) (*LinearModel, error) {
+	for _, point := range points {
+		if point.Price <= 0 {
+			return nil, fmt.Errorf("log model needs positive prices, got %v", point.Price)
+		}
+	}
+	return fitRegression("log", points, true, false)
+}
+
+// price ~ mileage + year fitted with huber weights, so a few mispriced listings have less pull on the line
+func FitHuber(points []Point) (*LinearModel, error) {
+	return fitRegression("huber", points, false, true)
+}
+
+func fitRegression(name string, points []Point, logPrice bool, robust bool) (*LinearModel, error) {
+	model := &LinearModel{name: name, logPrice: logPrice, means: map[string]float64{}, observations: len(points)}
+
+	//only use features that vary, centered so the normal equations stay well conditioned
+	for _, feature := range []string{"mileage", "year"} {
+		values := make([]float64, len(points))
+		for i, point := range points {
+			values[i] = featureValue(point, feature)
+		}
+		if variance(values) > 0 {
+			model.features = append(model.features, feature)
+			model.means[feature] = mean(values)
+		}
+	}
+
+	parameters := len(model.features) + 1
+	if len(points) <= parameters {
+		return nil, fmt.Errorf("%w, %d listings for %d parameters", ErrNotEnoughListings, len(points), parameters)
+	}
+
+	x := make([][]float64, len(points))
+	y := make([]float64, len(points))
+	for i, point := range points {
+		x[i] = model.row(point)
+		y[i] = model.response(point.Price)
+	}
+
+	var weights []float64
+	coefficients, inverse, err := leastSquares(x, y, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	//iteratively reweighted least squares, residuals beyond k robust standard deviations are down weighted
+	if robust {
+		weights = make([]float64, len(points))
+		for iteration := 0; iteration < 50; iteration++ {
+			residuals := make([]float64, len(points))
+			for i := range points {
+				residuals[i] = y[i] - dot(coefficients, x[i])
+			}
+
+			scale := medianAbsoluteDeviation(residuals) / 0.6745
+			if scale == 0 {
+				break
+			}
+
+			for i, residual := range residuals {
+				weights[i] = 1
+				if scaled := math.Abs(residual) / scale; scaled > huberK {
+					weights[i] = huberK / scaled
+				}
+			}
+
+			next, nextInverse, err := leastSquares(x, y, weights)
+			if err != nil {
+				return nil, err
+			}
+
+			change := 0.0
+			for i := range next {
+				change = math.Max(change, math.Abs(next[i]-coefficients[i]))
+			}
+			coefficients, inverse = next, nextInverse
+
+			if change < 1e-6 {
+				break
+			}
+		}
+	}
+
+	model.coefficients = coefficients
+	model.xtxInverse = inverse
+
+	//sigma is in the units the model was fitted in, r squared and priceSigma are always in dollars
+	meanPrice := 0.0
+	for _, point := range points {
+		meanPrice += point.Price / float64(len(points))
+	}
+
+	sse, priceSSE, priceSST, weightTotal := 0.0, 0.0, 0.0, 0.0
+	for i, point := range points {
+		w := 1.0
+		if weights != nil {
+			w = weights[i]
+		}
+		residual := y[i] - dot(coefficients, x[i])
+		sse += w * residual * residual
+		weightTotal += w
+
+		priceResidual := point.Price - model.price(dot(coefficients, x[i]))
+		priceSSE += priceResidual * priceResidual
+		priceSST += (point.Price - meanPrice) * (point.Price - meanPrice)
+	}
+
+	//weights scale the degrees of freedom down with the effective number of observations
+	df := weightTotal - float64(parameters)
+	if df <= 0 {
+		return nil, fmt.Errorf("%w after down weighting outliers", ErrNotEnoughListings)
+	}
+
+	model.df = df
+	model.sigma = math.Sqrt(sse / df)
+	model.priceSigma = math.Sqrt(priceSSE / float64(len(points)-parameters))
+	if priceSST > 0 {
+		model.rSquared = 1 - priceSSE/priceSST
+	}
+
+	return model, nil
+}
+
+func (m *LinearModel) Name() string {
+	return m.name
+}
+
+// the value the model is fitted on
+func (m *LinearModel) response(price float64) float64 {
+	if m.logPrice {
+		return math.Log(price)
+	}
+	return price
+}
+
+// back to dollars
+func (m *LinearModel) price(response float64) float64 {
+	if m.logPrice {
+		return math.Exp(response)
+	}
+	return response
+}
+
+// the design matrix row for a point, [1, centered features...]
+func (m *LinearModel) row(point Point) []float64 {
+	row := []float64{1}
+	for _, feature := range m.features {
+		row = append(row, featureValue(point, feature)-m.means[feature])
+	}
+	return row
+}
+
+// predicts the price of target, which only needs Mileage and Year set
+func (m *LinearModel) Estimate(target Point) Estimate {
+	row := m.row(target)
+	fitted := dot(m.coefficients, row)
+
+	//prediction intervals include the scatter of single cars around the fitted line, not just the
+	//uncertainty of the line itself. for the log model they are worked out on log prices and converted
+	//back, so they aren't symmetric around the prediction. the t quantile uses the same degrees of freedom
+	//as sigma, which are fewer than the observations when outliers were down weighted
+	spread := m.sigma * math.Sqrt(1+quadraticForm(row, m.xtxInverse))
+
+	intervals := make([]Interval, len(ConfidenceLevels))
+	for i, level := range ConfidenceLevels {
+		margin := tQuantile(level, m.df) * spread
+		intervals[i] = Interval{Confidence: level, Low: m.price(fitted - margin), High: m.price(fitted + margin)}
+	}
+
+	return Estimate{
+		Model:            m.name,
+		Price:            m.price(fitted),
+		Intervals:        intervals,
+		RSquared:         m.rSquared,
+		ResidualStdError: m.priceSigma,
+		Coefficients:     m.Coefficients(),
+		Observations:     m.observations,
+	}
+}
+
+// coefficients in the original units, e.g. price change per mile. the intercept is the price at
+// 0 miles in year 0 so it is only meaningful together with the others
+func (m *LinearModel) Coefficients() map[string]float64 {
+	coefficients := map[string]float64{"mileage": 0, "year": 0}
+	intercept := m.coefficients[0]
+
+	for i, feature := range m.features {
+		coefficients[feature] = m.coefficients[i+1]
+		intercept -= m.coefficients[i+1] * m.means[feature]
+	}
+
+	coefficients["intercept"] = intercept
+	return coefficients
+}
+
+func dot(a []float64, b []float64) float64 {
+	total := 0.0
+	for i := range a {
+		total += a[i] * b[i]
+	}
+	return total
+}
+
+func medianAbsoluteDeviation(values []float64) float64 {
+	median := quantile(values, 0.5)
+	deviations := make([]float64, len(values))
+	for i, value := range values {
+		deviations[i] = math.Abs(value - median)
+	}
+	return quantile(deviations, 0.5)
+}
+
+func variance(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+	average := mean(values)
+	total := 0.0
+	for _, value := range values {
+		total += (value - average) * (value - average)
+	}
+	return total / float64(len(values)-1)
+}
@@ -0,0 +1,140 @@
+package valuation
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestTQuantile(t *testing.T) {
+	//values from a t table
+	tests := []struct {
+		confidence float64
+		df         float64
+		want       float64
+	}{
+		{0.95, 1, 12.706},
+		{0.95, 2, 4.303},
+		{0.95, 5, 2.571},
+		{0.95, 10, 2.228},
+		{0.95, 30, 2.042},
+		{0.80, 5, 1.476},
+		{0.80, 20, 1.325},
+		{0.99, 8, 3.355},
+	}
+
+	for _, test := range tests {
+		if got := tQuantile(test.confidence, test.df); math.Abs(got-test.want) > 0.001 {
+			t.Errorf("tQuantile(%v, %v) = %.4f, want %.3f", test.confidence, test.df, got, test.want)
+		}
+	}
+}
+
+// prices that follow 20000 - 0.1/mile + 1500/year exactly, apart from a small alternating error
+func linearPoints() []Point {
+	points := []Point{}
+	for i, mileage := range []float64{10000, 25000, 40000, 55000, 70000, 85000} {
+		for _, year := range []float64{2016, 2018, 2020} {
+			noise := 300.0
+			if (i+int(year))%2 == 0 {
+				noise = -300
+			}
+			points = append(points, Point{Price: 20000 - 0.1*mileage + 1500*(year-2018) + noise, Mileage: mileage, Year: year})
+		}
+	}
+	return points
+}
+
+func TestFitLinear(t *testing.T) {
+	model, err := FitLinear(linearPoints())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	estimate := model.Estimate(Point{Mileage: 50000, Year: 2018})
+
+	if math.Abs(estimate.Price-15000) > 100 {
+		t.Errorf("predicted %.2f, want about 15000", estimate.Price)
+	}
+	if math.Abs(estimate.Coefficients["mileage"]+0.1) > 0.005 {
+		t.Errorf("mileage coefficient %.4f, want about -0.1", estimate.Coefficients["mileage"])
+	}
+	if math.Abs(estimate.Coefficients["year"]-1500) > 50 {
+		t.Errorf("year coefficient %.2f, want about 1500", estimate.Coefficients["year"])
+	}
+	if estimate.RSquared < 0.95 {
+		t.Errorf("r squared %.3f, want at least 0.95", estimate.RSquared)
+	}
+	if estimate.Observations != 18 {
+		t.Errorf("%d observations, want 18", estimate.Observations)
+	}
+
+	//the intercept should put the line through the data in the original units
+	intercept := estimate.Coefficients["intercept"] + estimate.Coefficients["mileage"]*50000 + estimate.Coefficients["year"]*2018
+	if math.Abs(intercept-estimate.Price) > 0.01 {
+		t.Errorf("coefficients give %.2f, prediction is %.2f", intercept, estimate.Price)
+	}
+
+	if len(estimate.Intervals) != 2 {
+		t.Fatalf("got %d intervals, want 2", len(estimate.Intervals))
+	}
+	narrow, wide := estimate.Intervals[0], estimate.Intervals[1]
+	if !(wide.Low < narrow.Low && narrow.Low < estimate.Price && estimate.Price < narrow.High && narrow.High < wide.High) {
+		t.Errorf("intervals aren't nested around the prediction: 80%% %+v, 95%% %+v", narrow, wide)
+	}
+}
+
+func TestFitLinearSingleYear(t *testing.T) {
+	points := []Point{}
+	for _, point := range linearPoints() {
+		if point.Year == 2018 {
+			points = append(points, point)
+		}
+	}
+
+	model, err := FitLinear(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	estimate := model.Estimate(Point{Mileage: 50000, Year: 2018})
+	if estimate.Coefficients["year"] != 0 {
+		t.Errorf("year coefficient %.2f, want 0 when every listing is the same year", estimate.Coefficients["year"])
+	}
+	if math.Abs(estimate.Price-15000) > 400 {
+		t.Errorf("predicted %.2f, want about 15000", estimate.Price)
+	}
+}
+
+func TestFitLinearNotEnoughListings(t *testing.T) {
+	_, err := FitLinear([]Point{{Price: 10000, Mileage: 10000, Year: 2018}, {Price: 9000, Mileage: 20000, Year: 2018}})
+	if !errors.Is(err, ErrNotEnoughListings) {
+		t.Errorf("got error %v, want ErrNotEnoughListings", err)
+	}
+}
+
+func TestHuberIntervalsUseWeightedDegreesOfFreedom(t *testing.T) {
+	points := linearPoints()
+	points = append(points, Point{Price: 90000, Mileage: 40000, Year: 2018}, Point{Price: 85000, Mileage: 55000, Year: 2018})
+
+	model, err := FitHuber(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parameters := float64(len(model.coefficients))
+	if model.df >= float64(len(points))-parameters {
+		t.Fatalf("df is %.2f, want fewer than %v once the mispriced listings are down weighted", model.df, float64(len(points))-parameters)
+	}
+
+	//the 95% margin over the 80% one only depends on the t quantiles, so it shows which df was used
+	target := Point{Mileage: 50000, Year: 2018}
+	estimate := model.Estimate(target)
+	narrow, wide := estimate.Intervals[0], estimate.Intervals[1]
+
+	got := (wide.High - estimate.Price) / (narrow.High - estimate.Price)
+	want := tQuantile(0.95, model.df) / tQuantile(0.80, model.df)
+	if math.Abs(got-want) > 1e-9 {
+		t.Errorf("95%% margin is %.6f times the 80%% one, want %.6f", got, want)
+	}
+}
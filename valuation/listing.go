@@ -0,0 +1,170 @@
+// Package valuation finds comparable listings and values vehicles from them. It doesn't depend on the
+// database or any other app config so sources and models can be tested on their own.
+package valuation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// conditions and seller types a listing can have, empty when the source doesn't say
+const (
+	ConditionNew       = "new"
+	ConditionUsed      = "used"
+	ConditionCertified = "certified"
+
+	SellerDealer  = "dealer"
+	SellerPrivate = "private"
+)
+
+// a vehicle for sale, normalized across sources
+type Listing struct {
+	Source     string `json:"source"`
+	VIN        string `json:"vin,omitempty"`
+	Title      string `json:"title,omitempty"`
+	Price      int    `json:"price"`
+	Mileage    int    `json:"mileage"`
+	Year       int    `json:"year"`
+	Make       string `json:"make,omitempty"`
+	Model      string `json:"model,omitempty"`
+	Trim       string `json:"trim,omitempty"`
+	Condition  string `json:"condition,omitempty"`
+	Location   string `json:"location,omitempty"`
+	Distance   int    `json:"distance,omitempty"`
+	SellerType string `json:"seller_type,omitempty"`
+	URL        string `json:"url,omitempty"`
+}
+
+//Distance is in miles from the searched zip code, 0 when the source doesn't say
+
+// miles searched around the zip code when no radius is given
+const DefaultRadius = 100
+
+// what to search for. Year is ignored when MultipleYears is set
+type SearchQuery struct {
+	Year          int
+	Make          string
+	Model         string
+	ZipCode       string
+	Radius        int
+	MultipleYears bool
+}
+
+// somewhere listings can be searched. implementations should return an error rather than an empty
+// result when a page can't be read, so a markup change doesn't look like a car nobody sells
+type ListingSource interface {
+	Name() string
+	Search(ctx context.Context, query SearchQuery) ([]Listing, error)
+}
+
+// creates a source, sources are created per request so they can hold per request state
+type SourceFactory func() ListingSource
+
+var (
+	registryMutex sync.RWMutex
+	registry      = map[string]SourceFactory{
+		"autotrader": func() ListingSource { return NewAutoTraderSource() },
+	}
+)
+
+// makes a source available to Sources under name
+func Register(name string, factory SourceFactory) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	registry[name] = factory
+}
+
+// creates the named sources in order, earlier sources win when listings are deduplicated
+func Sources(names []string) ([]ListingSource, error) {
+	registryMutex.RLock()
+	defer registryMutex.RUnlock()
+
+	sources := []ListingSource{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		factory, ok := registry[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown listing source %q, available sources are %s", name, strings.Join(registeredNames(), ", "))
+		}
+		sources = append(sources, factory())
+	}
+
+	if len(sources) == 0 {
+		return nil, errors.New("no listing sources configured")
+	}
+
+	return sources, nil
+}
+
+func registeredNames() []string {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// searches every source at the same time and merges the results. a listing found by more than one source
+// is only kept once, from the earliest source. the error joins every failed source, so listings can be
+// returned along with an error when only some sources failed
+func FetchListings(ctx context.Context, sources []ListingSource, query SearchQuery) ([]Listing, error) {
+	results := make([][]Listing, len(sources))
+	errs := make([]error, len(sources))
+
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func(i int, source ListingSource) {
+			defer wg.Done()
+			listings, err := source.Search(ctx, query)
+			if err != nil {
+				errs[i] = fmt.Errorf("%s: %w", source.Name(), err)
+				return
+			}
+			results[i] = listings
+		}(i, source)
+	}
+	wg.Wait()
+
+	return Deduplicate(results...), errors.Join(errs...)
+}
+
+// merges listings keeping the first listing for each VIN, or for each URL when there is no VIN
+func Deduplicate(sets ...[]Listing) []Listing {
+	seen := map[string]bool{}
+	merged := []Listing{}
+
+	for _, listings := range sets {
+		for _, listing := range listings {
+			id := listingID(listing)
+			if id != "" && seen[id] {
+				continue
+			}
+			if id != "" {
+				seen[id] = true
+			}
+			merged = append(merged, listing)
+		}
+	}
+
+	return merged
+}
+
+func listingID(listing Listing) string {
+	if listing.VIN != "" {
+		return "vin:" + strings.ToUpper(listing.VIN)
+	}
+	if listing.URL != "" {
+		return "url:" + listing.URL
+	}
+	return ""
+}
@@ -0,0 +1,200 @@
+package valuation
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// levels of the prediction intervals returned with every estimate
+var ConfidenceLevels = []float64{0.80, 0.95}
+
+var ErrNotEnoughListings = errors.New("not enough listings to fit a model")
+
+// the model used when none is asked for
+const DefaultModel = "linear"
+
+// a listing reduced to what the models use
+type Point struct {
+	Price   float64
+	Mileage float64
+	Year    float64
+}
+
+// a price range that should contain the price of a car like the target with the given confidence
+type Interval struct {
+	Confidence float64 `json:"confidence"`
+	Low        float64 `json:"low"`
+	High       float64 `json:"high"`
+}
+
+// a predicted price and how far it can be trusted
+type Estimate struct {
+	Model            string             `json:"model"`
+	Price            float64            `json:"predicted_price"`
+	Intervals        []Interval         `json:"prediction_intervals"`
+	RSquared         float64            `json:"r_squared"`
+	ResidualStdError float64            `json:"residual_standard_error"`
+	Coefficients     map[string]float64 `json:"coefficients,omitempty"`
+	Observations     int                `json:"observations"`
+	Clamped          bool               `json:"clamped"`
+	Fallbacks        []string           `json:"fallbacks,omitempty"`
+}
+
+//Coefficients are in the model's own units, log is per unit of log price and knn has none
+//Clamped is set when the prediction fell outside the observed prices and was pulled back in, intervals are
+//pulled in to the observed prices without setting it
+//Fallbacks lists the models that were tried first and why they couldn't be used
+
+// a fitted valuation model
+type Model interface {
+	Name() string
+	Estimate(target Point) Estimate
+}
+
+type Fitter func(points []Point) (Model, error)
+
+// models that can be asked for by name
+var fitters = map[string]Fitter{
+	"linear": func(points []Point) (Model, error) { return FitLinear(points) },
+	"log":    func(points []Point) (Model, error) { return FitLogLinear(points) },
+	"huber":  func(points []Point) (Model, error) { return FitHuber(points) },
+	"knn":    func(points []Point) (Model, error) { return FitKNN(points) },
+}
+
+// models tried in order when the requested one can't be fitted, knn comes last since it always fits
+var fallbackOrder = []string{"huber", "log", "linear", "knn"}
+
+func ModelNames() []string {
+	names := make([]string, 0, len(fitters))
+	for name := range fitters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func IsModel(name string) bool {
+	_, ok := fitters[name]
+	return ok
+}
+
+// fits the named model and predicts the target, falling back to the other models when it can't be fitted
+// or predicts something that isn't a number. predictions are clamped to the range of observed prices
+func EstimatePrice(points []Point, target Point, name string) (Estimate, error) {
+	estimates, err := EstimatePrices(points, []Point{target}, name)
+	if err != nil {
+		return Estimate{}, err
+	}
+	return estimates[0], nil
+}
+
+// like EstimatePrice for several targets from one fitted model, a model is only used if it can predict
+// all of them
+func EstimatePrices(points []Point, targets []Point, name string) ([]Estimate, error) {
+	return estimatePrices(points, targets, name, true)
+}
+
+// like EstimatePrices without clamping to the observed prices, forecasts go past the data on purpose and
+// clamping would flatten them. how far they can be trusted shows in the intervals and CurvePoint.Extrapolated,
+// prices are only kept from going below zero
+func ForecastPrices(points []Point, targets []Point, name string) ([]Estimate, error) {
+	return estimatePrices(points, targets, name, false)
+}
+
+func estimatePrices(points []Point, targets []Point, name string, clampToData bool) ([]Estimate, error) {
+	if name == "" {
+		name = DefaultModel
+	}
+	if !IsModel(name) {
+		return nil, fmt.Errorf("unknown model %q, available models are %s", name, strings.Join(ModelNames(), ", "))
+	}
+
+	order := []string{name}
+	for _, fallback := range fallbackOrder {
+		if fallback != name {
+			order = append(order, fallback)
+		}
+	}
+
+	fallbacks := []string{}
+	var lastErr error
+
+models:
+	for _, modelName := range order {
+		model, err := fitters[modelName](points)
+		if err != nil {
+			fallbacks = append(fallbacks, modelName+": "+err.Error())
+			lastErr = err
+			continue
+		}
+
+		estimates := make([]Estimate, len(targets))
+		for i, target := range targets {
+			estimate := model.Estimate(target)
+			if math.IsNaN(estimate.Price) || math.IsInf(estimate.Price, 0) {
+				fallbacks = append(fallbacks, modelName+": prediction is not a number")
+				lastErr = errors.New("prediction is not a number")
+				continue models
+			}
+
+			if clampToData {
+				estimates[i] = clamp(estimate, points)
+			} else {
+				estimates[i] = floorAtZero(estimate)
+			}
+			if len(fallbacks) > 0 {
+				estimates[i].Fallbacks = fallbacks
+			}
+		}
+		return estimates, nil
+	}
+
+	return nil, lastErr
+}
+
+// keeps the prediction and intervals within the observed prices, no model should value a car below the
+// cheapest comparable or above the most expensive one
+func clamp(estimate Estimate, points []Point) Estimate {
+	if len(points) == 0 {
+		return estimate
+	}
+
+	low, high := points[0].Price, points[0].Price
+	for _, point := range points {
+		low = math.Min(low, point.Price)
+		high = math.Max(high, point.Price)
+	}
+
+	clampValue := func(value float64) float64 {
+		return math.Max(low, math.Min(high, value))
+	}
+
+	if price := clampValue(estimate.Price); price != estimate.Price {
+		estimate.Price = price
+		estimate.Clamped = true
+	}
+
+	intervals := make([]Interval, len(estimate.Intervals))
+	for i, interval := range estimate.Intervals {
+		intervals[i] = Interval{Confidence: interval.Confidence, Low: clampValue(interval.Low), High: clampValue(interval.High)}
+	}
+	estimate.Intervals = intervals
+
+	return estimate
+}
+
+// a linear model can predict a negative price for a car far past the data
+func floorAtZero(estimate Estimate) Estimate {
+	estimate.Price = math.Max(0, estimate.Price)
+
+	intervals := make([]Interval, len(estimate.Intervals))
+	for i, interval := range estimate.Intervals {
+		intervals[i] = Interval{Confidence: interval.Confidence, Low: math.Max(0, interval.Low), High: math.Max(0, interval.High)}
+	}
+	estimate.Intervals = intervals
+
+	return estimate
+}
@@ -0,0 +1,126 @@
+package valuation
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// prices that halve roughly every 60,000 miles, which a straight line can't follow
+func depreciationPoints() []Point {
+	points := []Point{}
+	for _, mileage := range []float64{5000, 20000, 35000, 50000, 65000, 80000, 95000, 110000, 125000, 140000} {
+		for _, year := range []float64{2016, 2019} {
+			points = append(points, Point{Price: 30000 * math.Pow(0.5, mileage/60000) * math.Pow(1.08, year-2016), Mileage: mileage, Year: year})
+		}
+	}
+	return points
+}
+
+func TestModelsPredictWithinObservedPrices(t *testing.T) {
+	points := depreciationPoints()
+	target := Point{Mileage: 250000, Year: 2016}
+
+	for _, name := range ModelNames() {
+		t.Run(name, func(t *testing.T) {
+			estimate, err := EstimatePrice(points, target, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if estimate.Model != name {
+				t.Errorf("used model %s, want %s", estimate.Model, name)
+			}
+			if estimate.Price <= 0 {
+				t.Errorf("predicted %.2f for a high mileage car", estimate.Price)
+			}
+			for _, interval := range estimate.Intervals {
+				if interval.Low > estimate.Price || interval.High < estimate.Price {
+					t.Errorf("%v interval %.2f-%.2f doesn't contain %.2f", interval.Confidence, interval.Low, interval.High, estimate.Price)
+				}
+			}
+		})
+	}
+}
+
+func TestLinearIsClampedFarOutsideTheData(t *testing.T) {
+	estimate, err := EstimatePrice(depreciationPoints(), Point{Mileage: 400000, Year: 2016}, "linear")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !estimate.Clamped {
+		t.Errorf("expected the linear prediction at 400,000 miles to be clamped, got %.2f", estimate.Price)
+	}
+}
+
+func TestLogModelFitsMultiplicativeDepreciation(t *testing.T) {
+	model, err := FitLogLinear(depreciationPoints())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := 30000 * math.Pow(0.5, 100000.0/60000) * math.Pow(1.08, 3)
+	estimate := model.Estimate(Point{Mileage: 100000, Year: 2019})
+	if math.Abs(estimate.Price-want)/want > 0.01 {
+		t.Errorf("predicted %.2f, want %.2f", estimate.Price, want)
+	}
+}
+
+func TestHuberResistsMispricedListings(t *testing.T) {
+	points := linearPoints()
+	points = append(points, Point{Price: 90000, Mileage: 40000, Year: 2018}, Point{Price: 85000, Mileage: 55000, Year: 2018})
+
+	linear, err := FitLinear(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+	huber, err := FitHuber(points)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	target := Point{Mileage: 50000, Year: 2018}
+	linearError := math.Abs(linear.Estimate(target).Price - 15000)
+	huberError := math.Abs(huber.Estimate(target).Price - 15000)
+
+	if huberError >= linearError || huberError > 1000 {
+		t.Errorf("huber is off by %.2f and linear by %.2f, want huber much closer to 15000", huberError, linearError)
+	}
+}
+
+func TestKNNUsesNearestListings(t *testing.T) {
+	model, err := FitKNN(depreciationPoints())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	estimate := model.Estimate(Point{Mileage: 5000, Year: 2019})
+	if estimate.Price < 25000 {
+		t.Errorf("predicted %.2f for a low mileage car, want the price of the low mileage listings", estimate.Price)
+	}
+	if estimate.Coefficients != nil {
+		t.Errorf("knn shouldn't report coefficients, got %v", estimate.Coefficients)
+	}
+}
+
+func TestEstimatePriceFallsBack(t *testing.T) {
+	points := []Point{{Price: 20000, Mileage: 10000, Year: 2018}, {Price: 18000, Mileage: 30000, Year: 2019}, {Price: 15000, Mileage: 60000, Year: 2020}}
+
+	estimate, err := EstimatePrice(points, Point{Mileage: 30000, Year: 2019}, "linear")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if estimate.Model != "knn" {
+		t.Errorf("used %s, want knn after the regressions fail with 3 listings", estimate.Model)
+	}
+	if len(estimate.Fallbacks) != 3 || !strings.HasPrefix(estimate.Fallbacks[0], "linear: ") {
+		t.Errorf("got fallbacks %v, want linear, huber and log", estimate.Fallbacks)
+	}
+}
+
+func TestEstimatePriceUnknownModel(t *testing.T) {
+	if _, err := EstimatePrice(depreciationPoints(), Point{Mileage: 30000, Year: 2019}, "neural"); err == nil {
+		t.Error("expected an error for an unknown model")
+	}
+}
@@ -0,0 +1,156 @@
+package valuation
+
+import (
+	"errors"
+	"math"
+)
+
+var ErrSingular = errors.New("the listings don't vary enough to fit a model")
+
+// weighted least squares fit of y on the columns of x. returns the coefficients and (X'WX)^-1,
+// which the prediction intervals need
+func leastSquares(x [][]float64, y []float64, weights []float64) ([]float64, [][]float64, error) {
+	p := len(x[0])
+
+	xtx := make([][]float64, p)
+	xty := make([]float64, p)
+	for i := range xtx {
+		xtx[i] = make([]float64, p)
+	}
+
+	for row := range x {
+		w := 1.0
+		if weights != nil {
+			w = weights[row]
+		}
+		for i := 0; i < p; i++ {
+			xty[i] += w * x[row][i] * y[row]
+			for j := 0; j < p; j++ {
+				xtx[i][j] += w * x[row][i] * x[row][j]
+			}
+		}
+	}
+
+	inverse, err := invert(xtx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	coefficients := make([]float64, p)
+	for i := 0; i < p; i++ {
+		for j := 0; j < p; j++ {
+			coefficients[i] += inverse[i][j] * xty[j]
+		}
+	}
+
+	return coefficients, inverse, nil
+}
+
+// inverts a small square matrix with gauss-jordan elimination and partial pivoting
+func invert(matrix [][]float64) ([][]float64, error) {
+	n := len(matrix)
+
+	//work on [matrix | identity]
+	augmented := make([][]float64, n)
+	for i := range matrix {
+		augmented[i] = make([]float64, 2*n)
+		copy(augmented[i], matrix[i])
+		augmented[i][n+i] = 1
+	}
+
+	for column := 0; column < n; column++ {
+		pivot := column
+		for row := column + 1; row < n; row++ {
+			if math.Abs(augmented[row][column]) > math.Abs(augmented[pivot][column]) {
+				pivot = row
+			}
+		}
+
+		if math.Abs(augmented[pivot][column]) < 1e-10 {
+			return nil, ErrSingular
+		}
+		augmented[column], augmented[pivot] = augmented[pivot], augmented[column]
+
+		scale := augmented[column][column]
+		for j := range augmented[column] {
+			augmented[column][j] /= scale
+		}
+
+		for row := 0; row < n; row++ {
+			if row == column {
+				continue
+			}
+			factor := augmented[row][column]
+			for j := range augmented[row] {
+				augmented[row][j] -= factor * augmented[column][j]
+			}
+		}
+	}
+
+	inverse := make([][]float64, n)
+	for i := range augmented {
+		inverse[i] = augmented[i][n:]
+	}
+	return inverse, nil
+}
+
+// quadratic form v'Mv
+func quadraticForm(v []float64, m [][]float64) float64 {
+	total := 0.0
+	for i := range v {
+		for j := range v {
+			total += v[i] * m[i][j] * v[j]
+		}
+	}
+	return total
+}
+
+// the two sided critical value of student's t, e.g. tQuantile(0.95, 10) = 2.228.
+// uses Hill's approximation (algorithm 396), which is accurate to about 5 digits
+func tQuantile(confidence float64, df float64) float64 {
+	p := 1 - confidence
+
+	if df == 1 {
+		return math.Cos(p*math.Pi/2) / math.Sin(p*math.Pi/2)
+	}
+	if df == 2 {
+		return math.Sqrt(2/(p*(2-p)) - 2)
+	}
+
+	a := 1 / (df - 0.5)
+	b := 48 / (a * a)
+	c := ((20700*a/b-98)*a-16)*a + 96.36
+	d := ((94.5/(b+c)-3)/b + 1) * math.Sqrt(a*math.Pi/2) * df
+
+	y := math.Pow(d*p, 2/df)
+	if y > 0.05+a {
+		x := normalQuantile(1 - p/2)
+		y = x * x
+		if df < 5 {
+			c += 0.3 * (df - 4.5) * (x + 0.6)
+		}
+		c = (((0.05*d*x-5)*x-7)*x-2)*x + b + c
+		y = (((((0.4*y+6.3)*y+36)*y+94.5)/c-y-3)/b + 1) * x
+		y = math.Expm1(a * y * y)
+	} else {
+		y = ((1/(((df+6)/(df*y)-0.089*d-0.822)*(df+2)*3)+0.5/(df+4))*y-1)*(df+1)/(df+2) + 1/y
+	}
+
+	return math.Sqrt(df * y)
+}
+
+// inverse of the standard normal cdf
+func normalQuantile(p float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*p-1)
+}
+
+func mean(values []float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	total := 0.0
+	for _, value := range values {
+		total += value
+	}
+	return total / float64(len(values))
+}
@@ -0,0 +1,57 @@
+package vin
+
+import "strings"
+
+// matches a vPIC pattern against the start of a descriptor. the score is the number of characters
+// that had to match exactly or from a set, so more specific patterns score higher
+func MatchPattern(pattern string, descriptor string) (int, bool) {
+	score := 0
+	position := 0
+
+	for i := 0; i < len(pattern); i++ {
+		if position >= len(descriptor) {
+			return 0, false
+		}
+		char := descriptor[position]
+
+		switch pattern[i] {
+		case '*':
+		case '[':
+			end := strings.IndexByte(pattern[i:], ']')
+			if end == -1 {
+				return 0, false
+			}
+			if !matchSet(pattern[i+1:i+end], char) {
+				return 0, false
+			}
+			score++
+			i += end
+		default:
+			if pattern[i] != char {
+				return 0, false
+			}
+			score++
+		}
+
+		position++
+	}
+
+	return score, true
+}
+
+// matches a character against a set like "A-DGJ"
+func matchSet(set string, char byte) bool {
+	for i := 0; i < len(set); i++ {
+		if i+2 < len(set) && set[i+1] == '-' {
+			if char >= set[i] && char <= set[i+2] {
+				return true
+			}
+			i += 2
+			continue
+		}
+		if set[i] == char {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package vin
+
+import "testing"
+
+func TestMatchPattern(t *testing.T) {
+	//positions 4-8 and 10-17 of 1HGCM82633A004352
+	descriptor := "CM826|3A004352"
+
+	tests := []struct {
+		pattern string
+		score   int
+		ok      bool
+	}{
+		{"CM826", 5, true},
+		{"CM826|*A", 7, true},
+		{"CM8", 3, true},
+		{"**8", 1, true},
+		{"C[J-N]8", 3, true},
+		{"C[ABM]8", 3, true},
+		{"[A-C][K-M]82[4-7]|[1-3]", 7, true},
+		{"CM827", 0, false},
+		{"C[A-D]8", 0, false},
+		{"CM826|*B", 0, false},
+		//an unclosed set never matches
+		{"C[M", 0, false},
+		//a pattern longer than the descriptor
+		{"CM826|3A004352X", 0, false},
+	}
+
+	for _, test := range tests {
+		score, ok := MatchPattern(test.pattern, descriptor)
+		if ok != test.ok || score != test.score {
+			t.Errorf("%s: score %d, matched %t, want %d, %t", test.pattern, score, ok, test.score, test.ok)
+		}
+	}
+}
+
+func TestMatchPatternSpecificity(t *testing.T) {
+	descriptor := "CM826|3A004352"
+
+	general, _ := MatchPattern("CM***", descriptor)
+	specific, _ := MatchPattern("CM8[2-3]6", descriptor)
+	if specific <= general {
+		t.Errorf("the more specific pattern scored %d, the general one %d", specific, general)
+	}
+}
@@ -0,0 +1,134 @@
+// Package vin validates vehicle identification numbers without calling any upstream service.
+package vin
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// characters allowed in a VIN, I, O and Q are never used since they look like 1 and 0
+const alphabet = "ABCDEFGHJKLMNPRSTUVWXYZ0123456789"
+
+// values of each character for the check digit
+var transliteration = map[byte]int{
+	'A': 1, 'B': 2, 'C': 3, 'D': 4, 'E': 5, 'F': 6, 'G': 7, 'H': 8,
+	'J': 1, 'K': 2, 'L': 3, 'M': 4, 'N': 5, 'P': 7, 'R': 9,
+	'S': 2, 'T': 3, 'U': 4, 'V': 5, 'W': 6, 'X': 7, 'Y': 8, 'Z': 9,
+	'0': 0, '1': 1, '2': 2, '3': 3, '4': 4, '5': 5, '6': 6, '7': 7, '8': 8, '9': 9,
+}
+
+var weights = [17]int{8, 7, 6, 5, 4, 3, 2, 10, 0, 9, 8, 7, 6, 5, 4, 3, 2}
+
+// model year codes in position 10, the sequence repeats every 30 years starting in 1980
+const yearCodes = "ABCDEFGHJKLMNPRSTVWXY123456789"
+
+// a VIN that failed validation. Position is the 1 based position of the bad character, 0 if it is about the whole VIN
+type ValidationError struct {
+	Position int
+	Message  string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Message
+}
+
+// what can be worked out from a VIN offline
+type Info struct {
+	VIN             string `json:"vin"`
+	WMI             string `json:"wmi"`
+	Manufacturer    string `json:"manufacturer,omitempty"`
+	Region          string `json:"region"`
+	Country         string `json:"country,omitempty"`
+	ModelYear       int    `json:"model_year,omitempty"`
+	CheckDigitValid bool   `json:"check_digit_valid"`
+}
+
+//CheckDigitValid can only be false for VINs from outside North America and China, where the check digit is optional
+//ModelYear is 0 when position 10 isn't a model year code, which is allowed outside North America
+
+// validates a VIN and returns what is known about it. VINs for North America and China must have a valid
+// check digit and model year code, other regions don't require either so they are only reported
+func Parse(value string) (Info, error) {
+	value = Normalize(value)
+
+	if len(value) != 17 {
+		//VINs were only standardised to 17 characters for the 1981 model year
+		if len(value) >= 5 && len(value) < 17 {
+			return Info{}, &ValidationError{Message: "VIN must be 17 characters, vehicles built before 1981 don't have a standard VIN"}
+		}
+		return Info{}, &ValidationError{Message: "VIN must be 17 characters"}
+	}
+
+	for i := 0; i < len(value); i++ {
+		if strings.IndexByte(alphabet, value[i]) == -1 {
+			return Info{}, &ValidationError{Position: i + 1, Message: "VIN contains an invalid character '" + string(value[i]) + "' at position " + strconv.Itoa(i+1)}
+		}
+	}
+
+	info := Info{
+		VIN:    value,
+		WMI:    value[:3],
+		Region: Region(value),
+	}
+	info.Manufacturer, info.Country = LookupWMI(value)
+
+	strict := requiresCheckDigit(value)
+
+	info.CheckDigitValid = CheckDigit(value) == value[8]
+	if strict && !info.CheckDigitValid {
+		return Info{}, &ValidationError{Position: 9, Message: "VIN check digit is invalid, expected '" + string(CheckDigit(value)) + "' at position 9"}
+	}
+
+	info.ModelYear = ModelYear(value, time.Now().Year()+1)
+	if strict && info.ModelYear == 0 {
+		return Info{}, &ValidationError{Position: 10, Message: "VIN has an invalid model year code '" + string(value[9]) + "' at position 10"}
+	}
+
+	return info, nil
+}
+
+// uppercases and trims a VIN
+func Normalize(value string) string {
+	return strings.ToUpper(strings.TrimSpace(value))
+}
+
+// works out the check digit for a 17 character VIN, 'X' stands for 10
+func CheckDigit(value string) byte {
+	sum := 0
+	for i := 0; i < 17; i++ {
+		sum += transliteration[value[i]] * weights[i]
+	}
+
+	remainder := sum % 11
+	if remainder == 10 {
+		return 'X'
+	}
+	return byte('0' + remainder)
+}
+
+// returns the model year encoded in position 10, or 0 if there isn't one. Position 7 tells the two 30 year
+// cycles apart, a letter means 2010 or later. Imports follow the same rule when they're built for North America,
+// the later cycle is only dropped when it would be after maxYear
+func ModelYear(value string, maxYear int) int {
+	index := strings.IndexByte(yearCodes, value[9])
+	if index == -1 {
+		return 0
+	}
+
+	year := 1980 + index
+	if value[6] >= 'A' && value[6] <= 'Z' {
+		year += 30
+	}
+
+	//European VINs often fill position 7 with a letter whatever the year
+	if year > maxYear && year-30 >= 1980 {
+		year -= 30
+	}
+	return year
+}
+
+// the check digit is only required in North America and China
+func requiresCheckDigit(value string) bool {
+	return Region(value) == "North America" || value[0] == 'L'
+}
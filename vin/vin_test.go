@@ -0,0 +1,97 @@
+package vin
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCheckDigit(t *testing.T) {
+	for vin, want := range map[string]byte{
+		"1HGCM82633A004352": '3',
+		"1M8GDM9AXKP042788": 'X',
+		"JH4KA7561PC008269": '1',
+		"11111111111111111": '1',
+	} {
+		if got := CheckDigit(vin); got != want {
+			t.Errorf("%s: check digit is %c, want %c", vin, got, want)
+		}
+	}
+}
+
+func TestModelYear(t *testing.T) {
+	tests := []struct {
+		vin  string
+		want int
+	}{
+		//a digit in position 7 is the 1980 to 2009 cycle
+		{"1HGCM82633A004352", 2003},
+		{"1M8GDM9AXKP042788", 1989},
+		//a letter is 2010 or later
+		{"5YJ3E1EA2KF317000", 2019},
+		//imports use the same rule, this Legend is a 1993 not a 2023
+		{"JH4KA7561PC008269", 1993},
+		//a letter that would put the year after maxYear is filler
+		{"WVWZZZAUZXW000001", 1999},
+		{"WVWZZZAUZAW000001", 2010},
+		//U isn't a year code
+		{"WVWZZZ1JZUW000001", 0},
+	}
+
+	for _, test := range tests {
+		if got := ModelYear(test.vin, 2027); got != test.want {
+			t.Errorf("%s: model year is %d, want %d", test.vin, got, test.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	info, err := Parse(" jh4ka7561pc008269 ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.VIN != "JH4KA7561PC008269" || info.WMI != "JH4" || info.Manufacturer != "Acura" || info.Country != "Japan" || info.Region != "Asia" {
+		t.Errorf("info = %+v", info)
+	}
+	if info.ModelYear != 1993 || !info.CheckDigitValid {
+		t.Errorf("model year %d, check digit valid %t", info.ModelYear, info.CheckDigitValid)
+	}
+
+	//the check digit is optional in Europe so a wrong one is only reported
+	info, err = Parse("WVWZZZ1JZXW000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CheckDigitValid || info.Manufacturer != "Volkswagen" || info.Region != "Europe" {
+		t.Errorf("info = %+v", info)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	tests := []struct {
+		vin      string
+		position int
+	}{
+		{"1HGCM82633A00435", 0},
+		{"1HGCM8263", 0},
+		{"1HGCM82633A0043522", 0},
+		{"1HGCM8I633A004352", 7},
+		//North America and China require the check digit
+		{"1HGCM82643A004352", 9},
+		{"LFV2A21K6A3000001", 9},
+		//and a model year code
+		{"1HGCM8261UA004352", 10},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.vin)
+
+		var validation *ValidationError
+		if !errors.As(err, &validation) {
+			t.Errorf("%s: error is %v, want a validation error", test.vin, err)
+			continue
+		}
+		if validation.Position != test.position {
+			t.Errorf("%s: error at position %d, want %d: %s", test.vin, validation.Position, test.position, validation.Message)
+		}
+	}
+}
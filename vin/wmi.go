@@ -0,0 +1,134 @@
+package vin
+
+import (
+	_ "embed"
+	"encoding/csv"
+	"strings"
+)
+
+// world manufacturer identifiers for the makes we see most, one "wmi,manufacturer" per line
+//
+//go:embed wmi.csv
+var wmiCSV string
+
+var wmis = parseWMIs(wmiCSV)
+
+func parseWMIs(data string) map[string]string {
+	records, err := csv.NewReader(strings.NewReader(data)).ReadAll()
+	if err != nil {
+		panic("vin: invalid wmi.csv: " + err.Error())
+	}
+
+	table := make(map[string]string, len(records))
+	for _, record := range records {
+		table[record[0]] = record[1]
+	}
+	return table
+}
+
+type countryRange struct {
+	first   byte
+	from    byte
+	to      byte
+	country string
+}
+
+// order of the second character in country ranges
+const rangeOrder = "ABCDEFGHJKLMNPRSTUVWXYZ1234567890"
+
+// countries by the first two characters of the VIN, only the ranges in common use are listed
+var countries = []countryRange{
+	{'A', 'A', 'H', "South Africa"},
+	{'J', 'A', '0', "Japan"},
+	{'K', 'L', 'R', "South Korea"},
+	{'L', 'A', '0', "China"},
+	{'M', 'A', 'E', "India"},
+	{'M', 'F', 'K', "Indonesia"},
+	{'M', 'L', 'R', "Thailand"},
+	{'N', 'L', 'R', "Turkey"},
+	{'P', 'L', 'R', "Malaysia"},
+	{'S', 'A', 'M', "United Kingdom"},
+	{'S', 'U', 'Z', "Poland"},
+	{'T', 'A', 'H', "Switzerland"},
+	{'T', 'J', 'P', "Czech Republic"},
+	{'T', 'R', 'V', "Hungary"},
+	{'V', 'A', 'E', "Austria"},
+	{'V', 'F', 'R', "France"},
+	{'V', 'S', 'W', "Spain"},
+	{'W', 'A', '0', "Germany"},
+	{'X', 'L', 'R', "Netherlands"},
+	{'X', 'S', '0', "Russia"},
+	{'Y', 'A', 'E', "Belgium"},
+	{'Y', 'F', 'K', "Finland"},
+	{'Y', 'S', 'W', "Sweden"},
+	{'Z', 'A', 'R', "Italy"},
+	{'1', 'A', '0', "United States"},
+	{'2', 'A', '0', "Canada"},
+	{'3', 'A', 'W', "Mexico"},
+	{'4', 'A', '0', "United States"},
+	{'5', 'A', '0', "United States"},
+	{'6', 'A', 'W', "Australia"},
+	{'7', 'A', 'E', "New Zealand"},
+	{'9', 'A', 'E', "Brazil"},
+	{'9', '3', '9', "Brazil"},
+	{'8', 'A', 'E', "Argentina"},
+}
+
+// returns the region a VIN was assigned in from its first character
+func Region(value string) string {
+	switch first := value[0]; {
+	case first >= 'A' && first <= 'C':
+		return "Africa"
+	case first >= 'H' && first <= 'R':
+		return "Asia"
+	case first >= 'S' && first <= 'Z':
+		return "Europe"
+	case first >= '1' && first <= '5':
+		return "North America"
+	case first == '6' || first == '7':
+		return "Oceania"
+	case first == '8' || first == '9':
+		return "South America"
+	}
+	return "Unknown"
+}
+
+// the WMIs a VIN can be filed under, most specific first. small manufacturers share a WMI ending in 9 and
+// are told apart by positions 12 to 14, so they also have a 6 character WMI
+func WMIs(value string) []string {
+	if value[2] == '9' && len(value) >= 14 {
+		return []string{value[:3] + value[11:14], value[:3]}
+	}
+	return []string{value[:3]}
+}
+
+// the most specific of a VIN's WMIs that is in available, or "" if none are
+func PreferredWMI(value string, available []string) string {
+	for _, wmi := range WMIs(value) {
+		for _, candidate := range available {
+			if candidate == wmi {
+				return wmi
+			}
+		}
+	}
+	return ""
+}
+
+// returns the manufacturer and country for a VIN's WMI, either can be empty if it isn't in the tables.
+// a small manufacturer's 6 character WMI wins over the WMI it shares with the others
+func LookupWMI(value string) (manufacturer string, country string) {
+	for _, wmi := range WMIs(value) {
+		if manufacturer = wmis[wmi]; manufacturer != "" {
+			break
+		}
+	}
+
+	second := strings.IndexByte(rangeOrder, value[1])
+	for _, r := range countries {
+		if r.first == value[0] && second >= strings.IndexByte(rangeOrder, r.from) && second <= strings.IndexByte(rangeOrder, r.to) {
+			return manufacturer, r.country
+		}
+	}
+
+	return manufacturer, ""
+}
@@ -0,0 +1,50 @@
+package vin
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWMIs(t *testing.T) {
+	if got := WMIs("1HGCM82633A004352"); !reflect.DeepEqual(got, []string{"1HG"}) {
+		t.Errorf("WMIs = %v, want [1HG]", got)
+	}
+
+	//positions 12 to 14 tell small manufacturers apart
+	if got := WMIs("1Z9BC12345A123456"); !reflect.DeepEqual(got, []string{"1Z9123", "1Z9"}) {
+		t.Errorf("WMIs = %v, want [1Z9123 1Z9]", got)
+	}
+}
+
+func TestPreferredWMI(t *testing.T) {
+	tests := []struct {
+		available []string
+		want      string
+	}{
+		{[]string{"1Z9", "1Z9123", "1Z9"}, "1Z9123"},
+		{[]string{"1Z9"}, "1Z9"},
+		//another small manufacturer's patterns never match
+		{[]string{"1Z9456"}, ""},
+		{nil, ""},
+	}
+
+	for _, test := range tests {
+		if got := PreferredWMI("1Z9BC12345A123456", test.available); got != test.want {
+			t.Errorf("PreferredWMI(%v) = %q, want %q", test.available, got, test.want)
+		}
+	}
+}
+
+func TestLookupSmallManufacturer(t *testing.T) {
+	wmis["1Z9"] = "Shared"
+	wmis["1Z9123"] = "Small Motors"
+	defer delete(wmis, "1Z9")
+	defer delete(wmis, "1Z9123")
+
+	if manufacturer, _ := LookupWMI("1Z9BC12345A123456"); manufacturer != "Small Motors" {
+		t.Errorf("manufacturer is %q, want Small Motors", manufacturer)
+	}
+	if manufacturer, _ := LookupWMI("1Z9BC12345A456456"); manufacturer != "Shared" {
+		t.Errorf("manufacturer is %q, want Shared", manufacturer)
+	}
+}